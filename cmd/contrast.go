@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"image"
+
+	"go-image-generator/pkg/renderer"
+)
+
+// Defaults of the contrast check: the WCAG AA ratios for normal and large text, the size
+// from which text counts as large, and the look of backing panels
+const (
+	defaultMinContrast      = 4.5
+	defaultMinContrastLarge = 3
+	largeTextSize           = 24
+	defaultPanelOpacity     = 0.6
+	defaultPanelPadding     = 12
+)
+
+// adjustContrast checks the contrast of the text blocks against the pixels of img behind
+// them, which must not contain the texts yet. Texts below the minimum ratio of the
+// template take its candidate color with the highest contrast, then get its backing panel
+// drawn onto img if they still lack contrast. The colors of blocks are changed in place;
+// texts that remain below the ratio are returned as layout warnings.
+func (p *renderPlan) adjustContrast(img *image.RGBA, blocks []textBlock, record *renderRecord) []layoutIssue {
+	config := p.Template.Contrast
+	var issues []layoutIssue
+	for i := range blocks {
+		block := &blocks[i]
+		minRatio := config.MinRatio
+		if minRatio == 0 {
+			minRatio = defaultMinContrast
+		}
+		if block.Element.FontSize >= largeTextSize {
+			minRatio = config.MinRatioLarge
+			if minRatio == 0 {
+				minRatio = defaultMinContrastLarge
+			}
+		}
+
+		bounds := block.bounds()
+		original := block.Element.Color
+		ratio := renderer.TextContrast(img, bounds, original)
+		if ratio >= minRatio {
+			continue
+		}
+		block.Element.Color, ratio = bestContrast(img, bounds, block.Element.Color, ratio, config.Candidates)
+		if ratio < minRatio && config.Panel != nil {
+			panel := *config.Panel
+			if panel.Opacity == 0 {
+				panel.Opacity = defaultPanelOpacity
+			}
+			if panel.Padding == 0 {
+				panel.Padding = defaultPanelPadding
+			}
+			renderer.DrawPanel(img, bounds.Inset(-panel.Padding), panel.Color, panel.Opacity, panel.Radius)
+			block.Element.Color, ratio = bestContrast(img, bounds, original, renderer.TextContrast(img, bounds, original), config.Candidates)
+			record.log().Printf("Contrast in %s: %s has a panel in %s", p.name(), block.Name, panel.Color)
+		}
+		if block.Element.Color != original {
+			record.log().Printf("Contrast in %s: %s uses %s instead of %s (%.1f:1)", p.name(), block.Name, block.Element.Color, original, ratio)
+		}
+		if ratio < minRatio {
+			issues = append(issues, layoutIssue{
+				Severity: layoutWarning,
+				Message:  fmt.Sprintf("%s has a contrast of %.1f:1 in %s, below %.1f:1", block.Name, ratio, block.Element.Color, minRatio),
+			})
+		}
+	}
+	return issues
+}
+
+// bestContrast returns the candidate color with the highest contrast against the pixels
+// of img in bounds, or color if none is higher than its ratio
+func bestContrast(img *image.RGBA, bounds image.Rectangle, color string, ratio float64, candidates []string) (string, float64) {
+	for _, candidate := range candidates {
+		if r := renderer.TextContrast(img, bounds, candidate); r > ratio {
+			color, ratio = candidate, r
+		}
+	}
+	return color, ratio
+}
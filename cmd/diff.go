@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go-image-generator/pkg/imageio"
+	"go-image-generator/pkg/manifest"
+	"go-image-generator/pkg/metrics"
+	"go-image-generator/pkg/renderer"
+)
+
+// Statuses of a compared pair of images
+const (
+	diffUnchanged = "unchanged"
+	diffChanged   = "changed"
+	diffResized   = "resized"
+	diffAdded     = "added"
+	diffRemoved   = "removed"
+	diffFailed    = "error"
+)
+
+// diffEntry is an image compared by the diff subcommand: the same event and size in the
+// old and the new render. Added and removed images only have one of the paths.
+type diffEntry struct {
+	Key     string
+	EventID string
+	Variant string
+	Old     string
+	New     string
+	Status  string
+	// Changed is the share of pixels that differ noticeably, from 0 to 1
+	Changed   float64
+	MaxDeltaE float64
+	SSIM      float64
+	OldSize   image.Point
+	NewSize   image.Point
+	// Composite is the file name of the side-by-side image in the report directory
+	Composite string
+	Error     string
+}
+
+// diffCommand compares two images, or the images of two artifact directories paired by
+// event ID and size, and writes a report with a composite image of every change
+func diffCommand(args []string) {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	outputDir := flags.String("output", "diff-report", "Directory to write the HTML report and composite images to")
+	threshold := flags.Float64("threshold", metrics.JND, "Color difference (CIE76 ΔE) above which a pixel counts as changed")
+	tolerance := flags.Float64("tolerance", 0, "Percentage of changed pixels up to which an image counts as unchanged")
+	panelWidth := flags.Int("panel-width", 640, "Width of each image in the composites")
+	exitCode := flags.Bool("exit-code", false, "Exit with status 1 if any image changed, was resized, added or removed")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: diff [flags] <old> <new>\n\nCompares two images, or two directories of images paired by event ID and size.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	oldPath, newPath := flags.Arg(0), flags.Arg(1)
+	// Errors exit with status 2, so that they are not mistaken for changes with --exit-code
+	fatalf := func(format string, args ...any) {
+		log.Printf(format, args...)
+		os.Exit(2)
+	}
+
+	entries, err := pairImages(oldPath, newPath)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	if len(entries) == 0 {
+		fatalf("Error: no images found in %s or %s", oldPath, newPath)
+	}
+	if err := os.MkdirAll(*outputDir, 0755); err != nil {
+		fatalf("Error creating report directory: %v", err)
+	}
+	// Remove the composites of an earlier report
+	stale, _ := filepath.Glob(filepath.Join(*outputDir, "*-diff.jpg"))
+	for _, path := range stale {
+		os.Remove(path)
+	}
+
+	counts := make(map[string]int)
+	for i := range entries {
+		entry := &entries[i]
+		compareEntry(entry, *outputDir, *threshold, *tolerance/100, *panelWidth)
+		counts[entry.Status]++
+		// Identical images are only counted, to keep the changes readable
+		if entry.Status != diffUnchanged || entry.MaxDeltaE > 0 {
+			fmt.Printf("%-9s  %-24s %s\n", entry.Status, entry.Key, entry.summary())
+		}
+	}
+
+	reportPath := filepath.Join(*outputDir, "index.html")
+	if err := writeDiffReport(reportPath, oldPath, newPath, entries, counts); err != nil {
+		fatalf("Error writing report: %v", err)
+	}
+	fmt.Printf("Compared %d images: %d changed, %d resized, %d added, %d removed, %d unchanged, %d failed\n",
+		len(entries), counts[diffChanged], counts[diffResized], counts[diffAdded], counts[diffRemoved], counts[diffUnchanged], counts[diffFailed])
+	fmt.Println("Report:", reportPath)
+
+	if counts[diffFailed] > 0 {
+		os.Exit(2)
+	}
+	if *exitCode && len(entries) > counts[diffUnchanged] {
+		os.Exit(1)
+	}
+}
+
+// summary describes the scores of the entry for the console
+func (e diffEntry) summary() string {
+	switch e.Status {
+	case diffChanged, diffUnchanged:
+		return fmt.Sprintf("%.3f%% of pixels changed, max ΔE %.1f, SSIM %.4f", e.Changed*100, e.MaxDeltaE, e.SSIM)
+	case diffResized:
+		return fmt.Sprintf("%dx%d -> %dx%d", e.OldSize.X, e.OldSize.Y, e.NewSize.X, e.NewSize.Y)
+	case diffFailed:
+		return e.Error
+	}
+	return ""
+}
+
+// pairImages returns the images to compare: the two files if both paths are files, or
+// the images of both directories paired by their name without the extension, which is
+// the event ID and size of an output in any format
+func pairImages(oldPath, newPath string) ([]diffEntry, error) {
+	oldInfo, err := os.Stat(oldPath)
+	if err != nil {
+		return nil, err
+	}
+	newInfo, err := os.Stat(newPath)
+	if err != nil {
+		return nil, err
+	}
+	if oldInfo.IsDir() != newInfo.IsDir() {
+		return nil, fmt.Errorf("%s and %s must both be files or both be directories", oldPath, newPath)
+	}
+	if !oldInfo.IsDir() {
+		key := strings.TrimSuffix(filepath.Base(newPath), filepath.Ext(newPath))
+		id, variant := outputLabel(key, nil)
+		return []diffEntry{{Key: key, EventID: id, Variant: variant, Old: oldPath, New: newPath}}, nil
+	}
+
+	oldImages, err := directoryImages(oldPath)
+	if err != nil {
+		return nil, err
+	}
+	newImages, err := directoryImages(newPath)
+	if err != nil {
+		return nil, err
+	}
+	labels := manifestLabels(oldPath)
+	for key, label := range manifestLabels(newPath) {
+		labels[key] = label
+	}
+
+	var entries []diffEntry
+	for key, path := range oldImages {
+		id, variant := outputLabel(key, labels)
+		entries = append(entries, diffEntry{Key: key, EventID: id, Variant: variant, Old: path, New: newImages[key]})
+	}
+	for key, path := range newImages {
+		if _, ok := oldImages[key]; !ok {
+			id, variant := outputLabel(key, labels)
+			entries = append(entries, diffEntry{Key: key, EventID: id, Variant: variant, New: path})
+		}
+	}
+	slices.SortFunc(entries, func(a, b diffEntry) int {
+		if c := compareEventIDs(a.EventID, b.EventID); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Key, b.Key)
+	})
+	return entries, nil
+}
+
+// directoryImages returns the output images of dir by their name without the extension
+func directoryImages(dir string) (map[string]string, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	images := make(map[string]string)
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || imageio.FormatFromPath(name) == "" {
+			continue
+		}
+		images[strings.TrimSuffix(name, filepath.Ext(name))] = filepath.Join(dir, name)
+	}
+	return images, nil
+}
+
+// manifestLabels returns the event ID and variant of the outputs listed in the build
+// manifest of dir by their name without the extension, or an empty map without one
+func manifestLabels(dir string) map[string][2]string {
+	labels := make(map[string][2]string)
+	outputs, err := manifest.Read(filepath.Join(dir, manifest.FileName))
+	if err != nil {
+		return labels
+	}
+	for _, output := range outputs {
+		name := filepath.Base(output.Path)
+		labels[strings.TrimSuffix(name, filepath.Ext(name))] = [2]string{output.EventID, output.Variant}
+	}
+	return labels
+}
+
+// outputLabel returns the event ID and variant of an output named key, from labels or
+// else from the name itself, e.g. "44-550" is the 550 variant of event 44
+func outputLabel(key string, labels map[string][2]string) (string, string) {
+	if label, ok := labels[key]; ok {
+		return label[0], label[1]
+	}
+	if i := strings.IndexAny(key, "-@"); i > 0 {
+		return key[:i], strings.TrimPrefix(key[i:], "-")
+	}
+	return key, "default"
+}
+
+// compareEventIDs orders numeric event IDs by number and others by name after them
+func compareEventIDs(a, b string) int {
+	na, errA := strconv.Atoi(a)
+	nb, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		return na - nb
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// compareEntry decodes and compares the images of entry and sets its status and scores.
+// Images with more than tolerance of their pixels changed, and resized images, get a
+// composite of the old image, the new image and a heatmap of the changes in outputDir.
+func compareEntry(entry *diffEntry, outputDir string, threshold, tolerance float64, panelWidth int) {
+	fail := func(err error) {
+		entry.Status = diffFailed
+		entry.Error = err.Error()
+	}
+	switch {
+	case entry.Old == "":
+		entry.Status = diffAdded
+		return
+	case entry.New == "":
+		entry.Status = diffRemoved
+		return
+	}
+
+	oldData, err := os.ReadFile(entry.Old)
+	if err != nil {
+		fail(err)
+		return
+	}
+	newData, err := os.ReadFile(entry.New)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if bytes.Equal(oldData, newData) {
+		entry.Status = diffUnchanged
+		entry.SSIM = 1
+		return
+	}
+	oldImg, _, err := imageio.Decode(bytes.NewReader(oldData))
+	if err != nil {
+		fail(fmt.Errorf("%s: %w", entry.Old, err))
+		return
+	}
+	newImg, _, err := imageio.Decode(bytes.NewReader(newData))
+	if err != nil {
+		fail(fmt.Errorf("%s: %w", entry.New, err))
+		return
+	}
+	entry.OldSize, entry.NewSize = oldImg.Bounds().Size(), newImg.Bounds().Size()
+
+	panels := []image.Image{oldImg, newImg}
+	if entry.OldSize != entry.NewSize {
+		entry.Status = diffResized
+	} else {
+		diff, err := metrics.Diff(oldImg, newImg, threshold)
+		if err != nil {
+			fail(err)
+			return
+		}
+		if entry.SSIM, err = metrics.SSIM(oldImg, newImg); err != nil {
+			fail(err)
+			return
+		}
+		entry.Changed, entry.MaxDeltaE = diff.Changed, diff.MaxDeltaE
+		entry.Status = diffUnchanged
+		if entry.Changed <= tolerance {
+			return
+		}
+		entry.Status = diffChanged
+		panels = append(panels, diff.Heatmap)
+	}
+
+	entry.Composite = entry.Key + "-diff.jpg"
+	err = imageio.Save(filepath.Join(outputDir, entry.Composite), diffComposite(panels, panelWidth), imageio.EncodeOptions{Format: "jpeg", Quality: 90})
+	if err != nil {
+		fail(fmt.Errorf("error saving composite: %w", err))
+	}
+}
+
+// diffCompositeGap is the space in pixels between the panels of a composite
+const diffCompositeGap = 8
+
+// diffComposite places the panels next to each other on a dark background, each scaled
+// down to at most panelWidth
+func diffComposite(panels []image.Image, panelWidth int) *image.RGBA {
+	ir := renderer.ImageRenderer{}
+	scaled := make([]*image.RGBA, len(panels))
+	width, height := diffCompositeGap, 2*diffCompositeGap
+	for i, panel := range panels {
+		spec := renderer.ResizeSpec{}
+		if panel.Bounds().Dx() > panelWidth {
+			spec = renderer.ResizeSpec{Mode: renderer.ResizeWidth, Width: panelWidth}
+		}
+		scaled[i] = ir.Resize(panel, spec, nil)
+		width += scaled[i].Bounds().Dx() + diffCompositeGap
+		height = max(height, scaled[i].Bounds().Dy()+2*diffCompositeGap)
+	}
+
+	composite := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(composite, composite.Bounds(), image.NewUniform(color.RGBA{0x22, 0x22, 0x22, 0xff}), image.Point{}, draw.Src)
+	x := diffCompositeGap
+	for _, panel := range scaled {
+		r := panel.Bounds().Sub(panel.Bounds().Min).Add(image.Pt(x, diffCompositeGap))
+		draw.Draw(composite, r, panel, panel.Bounds().Min, draw.Src)
+		x += r.Dx() + diffCompositeGap
+	}
+	return composite
+}
+
+// diffPage is the HTML report of a diff, listing every image with its scores and the
+// composites of the changed ones
+var diffPage = template.Must(template.New("diff").Funcs(template.FuncMap{
+	"percent": func(v float64) string { return fmt.Sprintf("%.3f%%", v*100) },
+	"ratio":   func(v float64) string { return fmt.Sprintf("%.4f", v) },
+	"deltaE":  func(v float64) string { return fmt.Sprintf("%.1f", v) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Diff {{.Old}} → {{.New}}</title>
+<style>
+body { margin: 0; padding: 12px; font-family: sans-serif; background: #222; color: #eee; }
+table { border-collapse: collapse; margin-bottom: 24px; }
+th, td { padding: 4px 12px; text-align: left; border-bottom: 1px solid #444; }
+td.number { text-align: right; font-variant-numeric: tabular-nums; }
+a { color: #8cf; }
+.changed, .resized, .error { color: #f88; }
+.added { color: #8f8; }
+.removed { color: #fd6; }
+.unchanged { color: #888; }
+figure { margin: 0 0 24px; }
+figure img { display: block; max-width: 100%; }
+figcaption { padding: 4px 0; }
+</style>
+</head>
+<body>
+<h1>{{.Old}} → {{.New}}</h1>
+<p>{{len .Entries}} images: {{.Counts.changed}} changed, {{.Counts.resized}} resized, {{.Counts.added}} added, {{.Counts.removed}} removed, {{.Counts.unchanged}} unchanged{{with .Counts.error}}, {{.}} failed{{end}}. Composites show the old image, the new image and the changed pixels from yellow to red.</p>
+<table>
+<tr><th>Image</th><th>Event</th><th>Size</th><th>Status</th><th>Changed pixels</th><th>Max ΔE</th><th>SSIM</th></tr>
+{{- range .Entries}}
+<tr>
+<td>{{if .Composite}}<a href="#{{.Key}}">{{.Key}}</a>{{else}}{{.Key}}{{end}}</td>
+<td>{{.EventID}}</td>
+<td>{{.Variant}}</td>
+<td class="{{.Status}}">{{.Status}}{{with .Error}}: {{.}}{{end}}</td>
+{{- if or (eq .Status "changed") (eq .Status "unchanged")}}
+<td class="number">{{percent .Changed}}</td><td class="number">{{deltaE .MaxDeltaE}}</td><td class="number">{{ratio .SSIM}}</td>
+{{- else if eq .Status "resized"}}
+<td colspan="3">{{.OldSize.X}}x{{.OldSize.Y}} → {{.NewSize.X}}x{{.NewSize.Y}}</td>
+{{- else}}
+<td colspan="3"></td>
+{{- end}}
+</tr>
+{{- end}}
+</table>
+{{- range .Entries}}{{if .Composite}}
+<figure id="{{.Key}}">
+<figcaption>{{.Key}}: <span class="{{.Status}}">{{.Status}}</span></figcaption>
+<img src="{{.Composite}}" alt="{{.Key}} before, after and changes">
+</figure>
+{{- end}}{{end}}
+</body>
+</html>
+`))
+
+// writeDiffReport writes the HTML report of the compared entries to path
+func writeDiffReport(path, oldPath, newPath string, entries []diffEntry, counts map[string]int) error {
+	var buf bytes.Buffer
+	err := diffPage.Execute(&buf, struct {
+		Old     string
+		New     string
+		Entries []diffEntry
+		Counts  map[string]int
+	}{oldPath, newPath, entries, counts})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"flag"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-image-generator/pkg/imageio"
+	"go-image-generator/pkg/metrics"
+	"go-image-generator/pkg/renderer"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden images in cmd/testdata/golden")
+
+// Golden images are rendered from the sample events with the shipped template, in a
+// width small enough to keep the files in the repository but large enough to show a
+// shift of a pixel or two. Paths are relative to the repository root.
+const (
+	goldenEvents   = "_data/sample-events.yml"
+	goldenTemplate = "assets/templates/template.json"
+	goldenDir      = "cmd/testdata/golden"
+	goldenWidth    = "960"
+)
+
+// goldenEventIDs are the sample events whose speaker images are all local. The others
+// download images, which would make the test depend on the network.
+var goldenEventIDs = []string{"32", "45", "46", "47"}
+
+// Renders may differ from the goldens by floating point rounding across platforms, but
+// not visibly: at most goldenMaxChanged of the pixels may differ by more than a just
+// noticeable color difference, and the structure must stay at goldenMinSSIM.
+const (
+	goldenMaxChanged = 0.001
+	goldenMinSSIM    = 0.995
+)
+
+// TestGoldenImages renders the golden events and compares them with the images in
+// cmd/testdata/golden. Failed renders are written to cmd/testdata/golden/failed along
+// with a heatmap of the changed pixels. Run with -update to accept the new renders.
+func TestGoldenImages(t *testing.T) {
+	t.Chdir("..")
+	spec, err := renderer.ParseResizeSpec(goldenWidth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failedDir := filepath.Join(goldenDir, "failed")
+	os.RemoveAll(failedDir)
+
+	opts := renderOptions{Images: &imageio.Cache{}}
+	for _, id := range goldenEventIDs {
+		t.Run("event-"+id, func(t *testing.T) {
+			img := renderGolden(t, id, spec, opts)
+			goldenPath := filepath.Join(goldenDir, id+".png")
+			if *update {
+				if err := writePNG(goldenPath, img); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			golden, err := readPNG(goldenPath)
+			if os.IsNotExist(err) {
+				t.Fatalf("missing golden image %s, run go test ./cmd -run TestGoldenImages -update", goldenPath)
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if golden.Bounds().Size() != img.Bounds().Size() {
+				writeFailed(t, failedDir, id, img, nil)
+				t.Fatalf("size changed from %v to %v", golden.Bounds().Size(), img.Bounds().Size())
+			}
+			ssim, err := metrics.SSIM(golden, img)
+			if err != nil {
+				t.Fatal(err)
+			}
+			diff, err := metrics.Diff(golden, img, metrics.JND)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff.Changed > goldenMaxChanged || ssim < goldenMinSSIM {
+				writeFailed(t, failedDir, id, img, diff.Heatmap)
+				t.Errorf("render differs from %s: %.3f%% of pixels changed in %v (max ΔE %.1f), SSIM %.4f",
+					goldenPath, diff.Changed*100, diff.Bounds, diff.MaxDeltaE, ssim)
+			}
+		})
+	}
+}
+
+// renderGolden renders the sample event id with the shipped template in the size of spec
+func renderGolden(t *testing.T, id string, spec renderer.ResizeSpec, opts renderOptions) *image.RGBA {
+	t.Helper()
+	record := newBufferedRecord()
+	defer func() {
+		if t.Failed() {
+			t.Logf("render log:\n%s", record.logs.String())
+		}
+	}()
+
+	eventData, err := loadEventData(id, goldenEvents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := planEventImage(eventData, goldenTemplate, "", "", record.renderRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	composition, err := plan.render(opts, record.renderRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, warning := range record.Warnings {
+		t.Errorf("warning: %s", warning)
+	}
+	return plan.resize(composition, spec, opts)
+}
+
+// writeFailed saves the render of event id and the heatmap of its changes, if any, to dir
+func writeFailed(t *testing.T, dir, id string, img, heatmap *image.RGBA) {
+	t.Helper()
+	if err := writePNG(filepath.Join(dir, id+".png"), img); err != nil {
+		t.Error(err)
+	}
+	if heatmap != nil {
+		if err := writePNG(filepath.Join(dir, id+"-diff.png"), heatmap); err != nil {
+			t.Error(err)
+		}
+	}
+	t.Logf("wrote the render to %s", dir)
+}
+
+func readPNG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+func writePNG(path string, img image.Image) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
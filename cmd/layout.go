@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+
+	"go-image-generator/pkg/renderer"
+	"go-image-generator/pkg/types"
+)
+
+// textLineSpacing is the distance between the baselines of wrapped lines, relative to
+// the font size
+const textLineSpacing = 1.1
+
+// textBlock is a text element laid out on an image: the lines it wraps into, each drawn
+// from its dot on the baseline. Anchor is the position of the element, where the
+// baseline of its first line starts, and BoxWidth the width its lines are wrapped to.
+type textBlock struct {
+	Name     string
+	Element  types.TextElement
+	Anchor   image.Point
+	BoxWidth int
+	Lines    []textLine
+	// Ascent and Descent are the extent of the font above and below the baseline
+	Ascent  int
+	Descent int
+}
+
+// textLine is a line of a text block. Advance is the width of the line and Ink the
+// bounds of its glyphs. Wrapped is set on lines broken at a word to fit the box width.
+type textLine struct {
+	Text    string
+	Dot     image.Point
+	Advance int
+	Ink     image.Rectangle
+	Wrapped bool
+}
+
+// bounds returns the box of the lines of the block, from the ascent of the first line
+// to the descent of the last, or the empty rectangle if it has no lines
+func (b textBlock) bounds() image.Rectangle {
+	var r image.Rectangle
+	for _, line := range b.Lines {
+		r = r.Union(image.Rect(line.Dot.X, line.Dot.Y-b.Ascent, line.Dot.X+line.Advance, line.Dot.Y+b.Descent))
+	}
+	return r
+}
+
+// layoutTemplateText lays out the speaker pairs, sponsor, date, title and texts of a
+// template on an image of imgWidth x imgHeight, in the order they are drawn. Event data
+// must already be applied to the template and slots. Empty texts have no block.
+func layoutTemplateText(template *types.Template, slots []types.TalkSlot, imgWidth, imgHeight int, logger *log.Logger) ([]textBlock, error) {
+	var blocks []textBlock
+	add := func(block textBlock) {
+		if len(block.Lines) > 0 {
+			blocks = append(blocks, block)
+		}
+	}
+
+	for i, slot := range slots {
+		title, name, err := layoutSpeakerPair(slot.Title, slot.Name, imgWidth, imgHeight, logger)
+		if err != nil {
+			return nil, fmt.Errorf("speaker %d: %w", i+1, err)
+		}
+		title.Name = fmt.Sprintf("speaker %d title", i+1)
+		name.Name = fmt.Sprintf("speaker %d name", i+1)
+		add(title)
+		add(name)
+	}
+
+	elements := []struct {
+		name    string
+		element types.TextElement
+	}{{"sponsor", template.Sponsor}, {"date", template.Date}, {"title", template.Title}}
+	for i, element := range template.Texts {
+		elements = append(elements, struct {
+			name    string
+			element types.TextElement
+		}{fmt.Sprintf("text %d", i+1), element})
+	}
+	for _, e := range elements {
+		if e.element.Text == "" {
+			continue
+		}
+		x := int(e.element.Position.X * float64(imgWidth))
+		y := int(e.element.Position.Y * float64(imgHeight))
+		block, err := layoutTextBlock(e.element, x, y, int(e.element.BoxWidth*float64(imgWidth)), logger)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", e.name, err)
+		}
+		block.Name = e.name
+		add(block)
+	}
+	return blocks, nil
+}
+
+// layoutSpeakerPair lays out the title of a talk and, below it with half a line of
+// spacing, the speaker name. Both start at the position of the title.
+func layoutSpeakerPair(titleElement, nameElement types.TextElement, imgWidth, imgHeight int, logger *log.Logger) (textBlock, textBlock, error) {
+	titleX := int(titleElement.Position.X * float64(imgWidth))
+	titleY := int(titleElement.Position.Y * float64(imgHeight))
+
+	title, err := layoutTextBlock(titleElement, titleX, titleY, int(titleElement.BoxWidth*float64(imgWidth)), logger)
+	if err != nil {
+		return textBlock{}, textBlock{}, fmt.Errorf("title: %w", err)
+	}
+	nameY := titleY + int(float64(len(title.Lines))*titleElement.FontSize*textLineSpacing) + int(nameElement.FontSize*0.5)
+	name, err := layoutTextBlock(nameElement, titleX, nameY, int(nameElement.BoxWidth*float64(imgWidth)), logger)
+	if err != nil {
+		return textBlock{}, textBlock{}, fmt.Errorf("name: %w", err)
+	}
+	return title, name, nil
+}
+
+// layoutTextBlock wraps the paragraphs of element to boxWidth and places its lines
+// below each other, starting with the baseline of the first line at (x, y). Explicit
+// line breaks in the text are kept, e.g. for stacked speaker names.
+func layoutTextBlock(element types.TextElement, x, y, boxWidth int, logger *log.Logger) (textBlock, error) {
+	parsed, err := renderer.LoadFont(element.Font)
+	if err != nil {
+		return textBlock{}, fmt.Errorf("error loading font: %w", err)
+	}
+	// Measure with the face the text is drawn with
+	face, err := opentype.NewFace(parsed, &opentype.FaceOptions{Size: element.FontSize, DPI: 72})
+	if err != nil {
+		return textBlock{}, fmt.Errorf("failed to create font face: %w", err)
+	}
+	defer face.Close()
+
+	metrics := face.Metrics()
+	block := textBlock{
+		Element:  element,
+		Anchor:   image.Pt(x, y),
+		BoxWidth: boxWidth,
+		Ascent:   metrics.Ascent.Ceil(),
+		Descent:  metrics.Descent.Ceil(),
+	}
+	for _, paragraph := range strings.Split(element.Text, "\n") {
+		lines, err := wrapParagraph(paragraph, boxWidth, parsed, element.FontSize, logger)
+		if err != nil {
+			return textBlock{}, err
+		}
+		for i, text := range lines {
+			dot := image.Pt(x, y+int(float64(len(block.Lines))*element.FontSize*textLineSpacing))
+			ink, advance := font.BoundString(face, text)
+			block.Lines = append(block.Lines, textLine{
+				Text:    text,
+				Dot:     dot,
+				Advance: advance.Ceil(),
+				Ink:     image.Rect(dot.X+ink.Min.X.Floor(), dot.Y+ink.Min.Y.Floor(), dot.X+ink.Max.X.Ceil(), dot.Y+ink.Max.Y.Ceil()),
+				Wrapped: i < len(lines)-1,
+			})
+		}
+	}
+	return block, nil
+}
+
+// drawTextBlocks draws the lines of the blocks onto img
+func drawTextBlocks(img *image.RGBA, blocks []textBlock) error {
+	textRenderer := renderer.TextRenderer{}
+	for _, block := range blocks {
+		element := block.Element
+		for _, line := range block.Lines {
+			err := textRenderer.RenderTextWithPositionAndColor(img, line.Text, element.Font, element.FontSize, element.Color, line.Dot.X, line.Dot.Y)
+			if err != nil {
+				return fmt.Errorf("%s: error rendering text: %w", block.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Colors of the layout debug overlay
+var (
+	debugBoxColor      = color.RGBA{0x00, 0xe5, 0xff, 0xff} // text boxes
+	debugLimitColor    = color.RGBA{0xff, 0x00, 0xff, 0xff} // box width limits
+	debugBaselineColor = color.RGBA{0xff, 0xd4, 0x00, 0xff} // baselines
+	debugWrapColor     = color.RGBA{0xff, 0x7a, 0x00, 0xff} // wrap points
+	debugAnchorColor   = color.RGBA{0xff, 0x17, 0x44, 0xff} // anchor points
+	debugAvatarColor   = color.RGBA{0x00, 0xe6, 0x76, 0xff} // avatar bounds
+)
+
+// avatarBlock is a group of avatars drawn by an image element, in the bounds of the
+// element and with one circle per image
+type avatarBlock struct {
+	Name    string
+	Bounds  image.Rectangle
+	Circles []image.Rectangle
+}
+
+// avatarBlocks returns the avatars of the plan on an image of width x height. They are
+// named after the file of their first image.
+func (p *renderPlan) avatarBlocks(width, height int) []avatarBlock {
+	var blocks []avatarBlock
+	for i, circles := range renderer.SpeakerImageBounds(width, height, p.SpeakerImages, p.ImageElements) {
+		if len(circles) == 0 {
+			continue
+		}
+		element := p.ImageElements[i]
+		center := image.Pt(int(element.Position.X*float64(width)), int(element.Position.Y*float64(height)))
+		half := element.Size / 2
+		bounds := image.Rect(center.X-half, center.Y-half, center.X-half+element.Size, center.Y-half+element.Size)
+		for _, circle := range circles {
+			bounds = bounds.Union(circle)
+		}
+		blocks = append(blocks, avatarBlock{
+			Name:    "image " + filepath.Base(p.SpeakerImages[i][0].Path),
+			Bounds:  bounds,
+			Circles: circles,
+		})
+	}
+	return blocks
+}
+
+// drawLayoutDebug draws the layout of the text blocks and avatars on top of img: the box
+// of each text, the limit of its box width, its baselines, wrap points and anchor, and
+// the bounds of each avatar, labeled with the names of the elements
+func drawLayoutDebug(img *image.RGBA, blocks []textBlock, avatars []avatarBlock) {
+	pen := renderer.NewDebugPen(img)
+	arm := 6 * pen.Width
+	for _, avatar := range avatars {
+		pen.Rect(avatar.Bounds, debugAvatarColor)
+		for _, circle := range avatar.Circles {
+			pen.Circle(circle, debugAvatarColor)
+		}
+		pen.Cross(image.Pt((avatar.Bounds.Min.X+avatar.Bounds.Max.X)/2, (avatar.Bounds.Min.Y+avatar.Bounds.Max.Y)/2), arm, debugAnchorColor)
+		pen.Label(avatar.Bounds.Min, avatar.Name, debugAvatarColor)
+	}
+	for _, block := range blocks {
+		bounds := block.bounds()
+		pen.Rect(bounds, debugBoxColor)
+		limit := block.Anchor.X + block.BoxWidth
+		pen.DashedVLine(limit, bounds.Min.Y, bounds.Max.Y, debugLimitColor)
+		for _, line := range block.Lines {
+			pen.HLine(line.Dot.X, line.Dot.X+line.Advance, line.Dot.Y, debugBaselineColor)
+			if line.Wrapped {
+				pen.VLine(line.Dot.X+line.Advance, line.Dot.Y-block.Ascent, line.Dot.Y+block.Descent, debugWrapColor)
+			}
+		}
+		pen.Cross(block.Anchor, arm, debugAnchorColor)
+		pen.Label(bounds.Min, block.Name, debugBoxColor)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"strings"
+)
+
+// Modes of the layout check. The default reports layout problems as warnings.
+const (
+	lintWarn  = ""
+	lintError = "error"
+	lintOff   = "off"
+)
+
+// parseLintMode validates the --lint flag: warn, error or off
+func parseLintMode(mode string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "", "warn":
+		return lintWarn, nil
+	case lintError:
+		return lintError, nil
+	case lintOff:
+		return lintOff, nil
+	}
+	return "", fmt.Errorf("unknown layout check mode %q (available: warn, error, off)", mode)
+}
+
+// Severities of layout problems
+const (
+	layoutWarning = "warning"
+	layoutError   = "error"
+)
+
+// layoutIssue is a problem found in the layout of an image
+type layoutIssue struct {
+	Severity string
+	Message  string
+}
+
+// boxTolerance is how far in pixels the glyphs of a line may reach past its box width
+// before it counts as overflowing. Lines are wrapped using hinted widths, which differ
+// from the drawn glyphs by a pixel or two.
+const boxTolerance = 2
+
+// lintLayout checks the text blocks and avatars laid out on an image with the given
+// bounds. Texts reaching beyond the image, texts overlapping an avatar or another text,
+// and avatars reaching beyond the image are errors; lines wider than their box and
+// texts or avatars within safeMargin pixels of the edges are warnings.
+func lintLayout(blocks []textBlock, avatars []avatarBlock, bounds image.Rectangle, safeMargin int) []layoutIssue {
+	var issues []layoutIssue
+	add := func(severity, format string, args ...any) {
+		issues = append(issues, layoutIssue{Severity: severity, Message: fmt.Sprintf(format, args...)})
+	}
+	checkEdges := func(name string, r image.Rectangle) {
+		if sides := outside(r, bounds, 0); sides != "" {
+			add(layoutError, "%s extends beyond the %dx%d image: %s", name, bounds.Dx(), bounds.Dy(), sides)
+		} else if safeMargin > 0 {
+			if sides := outside(r, bounds, safeMargin); sides != "" {
+				add(layoutWarning, "%s is within the safe margin of %dpx: %s", name, safeMargin, sides)
+			}
+		}
+	}
+
+	for i, block := range blocks {
+		var ink image.Rectangle
+		limit := block.Anchor.X + block.BoxWidth
+		for _, line := range block.Lines {
+			ink = ink.Union(line.Ink)
+			if over := line.Ink.Max.X - limit; over > boxTolerance {
+				add(layoutWarning, "%s is %dpx wider than its box width of %dpx: %q", block.Name, over, block.BoxWidth, line.Text)
+			}
+		}
+		checkEdges(block.Name, ink)
+
+		for _, other := range blocks[i+1:] {
+			if overlap := linesOverlap(block, other); !overlap.Empty() {
+				add(layoutError, "%s overlaps %s by %dx%dpx", block.Name, other.Name, overlap.Dx(), overlap.Dy())
+			}
+		}
+		for _, avatar := range avatars {
+			if textOverlapsAvatar(block, avatar) {
+				add(layoutError, "%s overlaps %s", block.Name, avatar.Name)
+			}
+		}
+	}
+
+	for _, avatar := range avatars {
+		var circles image.Rectangle
+		for _, circle := range avatar.Circles {
+			circles = circles.Union(circle)
+		}
+		checkEdges(avatar.Name, circles)
+	}
+	return issues
+}
+
+// outside describes how far r reaches past the edges of bounds inset by margin, e.g.
+// "24px past the right edge", or returns "" if r lies inside
+func outside(r, bounds image.Rectangle, margin int) string {
+	inner := bounds.Inset(margin)
+	var sides []string
+	for _, side := range []struct {
+		name string
+		over int
+	}{
+		{"left", inner.Min.X - r.Min.X},
+		{"top", inner.Min.Y - r.Min.Y},
+		{"right", r.Max.X - inner.Max.X},
+		{"bottom", r.Max.Y - inner.Max.Y},
+	} {
+		if side.over <= 0 {
+			continue
+		}
+		if margin > 0 {
+			sides = append(sides, fmt.Sprintf("%dpx from the %s edge", margin-side.over, side.name))
+		} else {
+			sides = append(sides, fmt.Sprintf("%dpx past the %s edge", side.over, side.name))
+		}
+	}
+	return strings.Join(sides, ", ")
+}
+
+// linesOverlap returns the union of the overlaps between the glyphs of the lines of two
+// text blocks, or the empty rectangle if they do not overlap
+func linesOverlap(a, b textBlock) image.Rectangle {
+	var overlap image.Rectangle
+	for _, la := range a.Lines {
+		for _, lb := range b.Lines {
+			overlap = overlap.Union(la.Ink.Intersect(lb.Ink))
+		}
+	}
+	return overlap
+}
+
+// textOverlapsAvatar reports whether the glyphs of a line of the block reach into one of
+// the avatar circles
+func textOverlapsAvatar(block textBlock, avatar avatarBlock) bool {
+	for _, line := range block.Lines {
+		for _, circle := range avatar.Circles {
+			if rectOverlapsCircle(line.Ink, circle) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rectOverlapsCircle reports whether r overlaps the circle inscribed in bounds
+func rectOverlapsCircle(r, bounds image.Rectangle) bool {
+	if r.Empty() || !r.Overlaps(bounds) {
+		return false
+	}
+	cx, cy := float64(bounds.Min.X+bounds.Max.X)/2, float64(bounds.Min.Y+bounds.Max.Y)/2
+	radius := float64(min(bounds.Dx(), bounds.Dy())) / 2
+	// The point of r closest to the center
+	x := min(max(cx, float64(r.Min.X)), float64(r.Max.X))
+	y := min(max(cy, float64(r.Min.Y)), float64(r.Max.Y))
+	return (x-cx)*(x-cx)+(y-cy)*(y-cy) < radius*radius
+}
+
+// lint checks the layout of the plan and reports the problems found to record, along
+// with the given contrast issues. In the error mode of opts, layout errors fail the image.
+func (p *renderPlan) lint(blocks []textBlock, avatars []avatarBlock, contrast []layoutIssue, bounds image.Rectangle, opts renderOptions, record *renderRecord) error {
+	if opts.Lint == lintOff || p.Template == nil {
+		return nil
+	}
+	failed := 0
+	for _, issue := range append(lintLayout(blocks, avatars, bounds, p.Template.Lint.SafeMargin), contrast...) {
+		record.warnf("Layout %s in %s: %s", issue.Severity, p.name(), issue.Message)
+		if issue.Severity == layoutError {
+			failed++
+		}
+	}
+	if failed > 0 && opts.Lint == lintError {
+		return fmt.Errorf("%d layout error(s) in %s", failed, p.name())
+	}
+	return nil
+}
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -10,9 +14,15 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
+	"go-image-generator/pkg/imageio"
+	"go-image-generator/pkg/manifest"
+	"go-image-generator/pkg/metrics"
 	"go-image-generator/pkg/renderer"
 	"go-image-generator/pkg/templates"
 	"go-image-generator/pkg/types"
@@ -28,50 +38,366 @@ import (
 // Constants
 const (
 	EVENTS_URL = "https://raw.githubusercontent.com/CloudNativeLinz/cloudnativelinz.github.io/refs/heads/main/_data/events.yml"
+
+	// generatorVersion is part of the fingerprint of every output. Increase it when a
+	// change to the rendering code changes generated images, so that they are regenerated.
+	generatorVersion = "2"
 )
 
-// renderTextFromTemplate renders all text elements from a template onto the image
-func renderTextFromTemplate(templatePath string, eventData *types.EventData, rgbaFinalImage *image.RGBA, template *types.Template) error {
-	if templatePath == "" || template == nil {
-		return nil // No template provided, skip text rendering
+// renderOptions holds the command-line settings that control how images are rendered and saved.
+// Fields that do not affect the output are excluded from the fingerprint of an output.
+type renderOptions struct {
+	SmartCrop   bool
+	DebugCrop   bool            `json:"-"`
+	Report      *imageio.Report `json:"-"`
+	Format      string
+	Transparent bool
+	Quality     int
+	Subsampling string
+	MaxBytes    int
+	// Metadata enables embedded EXIF/XMP metadata and .alt.txt sidecar files
+	Metadata       bool
+	MetadataConfig types.MetadataConfig
+	Manifest       *manifest.Manifest `json:"-"`
+	// Images holds decoded images shared by all outputs of the run
+	Images *imageio.Cache `json:"-"`
+	// Force regenerates outputs whose fingerprint is unchanged
+	Force bool `json:"-"`
+	// Resample selects how images are scaled; the default filter is left empty
+	Resample types.ResampleConfig `json:",omitzero"`
+	// DebugLayout draws the boxes, baselines and anchors of the elements on top of the image
+	DebugLayout bool `json:",omitempty"`
+	// Lint selects whether layout problems are reported as warnings (the default), fail
+	// the image, or are not checked
+	Lint string `json:",omitempty"`
+	// Context stops a render between its steps once it is done, e.g. when the request
+	// asking for the image times out; nil renders to the end
+	Context context.Context `json:"-"`
+}
+
+// canceled returns the error of the context of the render once it is done
+func (o renderOptions) canceled() error {
+	if o.Context == nil {
+		return nil
 	}
+	return o.Context.Err()
+}
 
-	// Apply event data to template if available
-	if eventData != nil {
-		applyEventDataToTemplate(template, eventData)
+// renderRecord collects the speaker images used and the warnings logged while
+// rendering one image, for the build manifest. Log and progress output of the image
+// goes to its logger and out writer; a nil record uses the standard logger and stdout.
+type renderRecord struct {
+	SpeakerImages []string
+	Warnings      []string
+	logger        *log.Logger
+	out           io.Writer
+}
+
+// newRenderRecord returns a record that writes its output directly
+func newRenderRecord() *renderRecord {
+	return &renderRecord{logger: log.Default(), out: os.Stdout}
+}
+
+// bufferedRecord is a render record whose output is held back until flush, so that
+// images rendered in parallel are logged in a deterministic order
+type bufferedRecord struct {
+	*renderRecord
+	logs   bytes.Buffer
+	output bytes.Buffer
+}
+
+// newBufferedRecord returns a record that buffers its log and progress output
+func newBufferedRecord() *bufferedRecord {
+	b := &bufferedRecord{renderRecord: &renderRecord{}}
+	b.renderRecord.logger = log.New(&b.logs, log.Prefix(), log.Flags())
+	b.renderRecord.out = &b.output
+	return b
+}
+
+// flush writes the buffered output to the standard logger and stdout
+func (b *bufferedRecord) flush() {
+	log.Writer().Write(b.logs.Bytes())
+	os.Stdout.Write(b.output.Bytes())
+	b.logs.Reset()
+	b.output.Reset()
+}
+
+// log returns the logger of the record
+func (r *renderRecord) log() *log.Logger {
+	if r == nil || r.logger == nil {
+		return log.Default()
+	}
+	return r.logger
+}
+
+// printf writes progress output of the record
+func (r *renderRecord) printf(format string, args ...any) {
+	if r == nil || r.out == nil {
+		fmt.Printf(format, args...)
+		return
 	}
+	fmt.Fprintf(r.out, format, args...)
+}
 
-	imgWidth := rgbaFinalImage.Bounds().Dx()
-	imgHeight := rgbaFinalImage.Bounds().Dy()
-	lineSpacing := 1.1
-	textRenderer := renderer.TextRenderer{}
+// warnf logs a warning and records it
+func (r *renderRecord) warnf(format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	r.log().Printf("Warning: %s", message)
+	if r != nil {
+		r.Warnings = append(r.Warnings, message)
+	}
+}
 
-	// Render speaker pairs and other text elements
-	if err := renderSpeakerPair(&textRenderer, rgbaFinalImage, template.Speaker1title, template.Speaker1name, imgWidth, imgHeight, lineSpacing); err != nil {
-		log.Printf("Error rendering speaker 1: %v", err)
+// outputVariant returns the manifest variant name of an output rendered at spec
+func outputVariant(spec renderer.ResizeSpec) string {
+	if spec.IsFull() {
+		return "default"
 	}
+	return spec.String()
+}
+
+// recordOutput adds a generated image, or the error that prevented it, to the build manifest
+func recordOutput(m *manifest.Manifest, eventID string, target outputTarget, img image.Image, templatePath, backgroundPath, overlayPaths string, record *renderRecord, fingerprint string, renderErr error) {
+	if m == nil {
+		return
+	}
+
+	output := manifest.Output{
+		EventID:     eventID,
+		Variant:     target.Size.variant(),
+		Path:        target.Path,
+		Fingerprint: fingerprint,
+		Inputs:      outputInputs(m, templatePath, backgroundPath, overlayPaths, record),
+		Warnings:    record.Warnings,
+	}
+
+	if renderErr != nil {
+		output.Error = renderErr.Error()
+		m.Add(output)
+		return
+	}
+
+	output.Width = img.Bounds().Dx()
+	output.Height = img.Bounds().Dy()
+	if info, err := os.Stat(target.Path); err == nil {
+		output.Bytes = info.Size()
+	}
+	if sum, err := manifest.HashFile(target.Path); err == nil {
+		output.SHA256 = sum
+	}
+	if target.AltText != "" && fileExists(target.AltText) {
+		output.AltText = target.AltText
+	}
+	m.Add(output)
+}
+
+// outputInputs returns the hashes of the files an image was rendered from
+func outputInputs(m *manifest.Manifest, templatePath, backgroundPath, overlayPaths string, record *renderRecord) manifest.Inputs {
+	var inputs manifest.Inputs
+	if templatePath != "" {
+		template := m.Hash(templatePath)
+		inputs.Template = &template
+	}
+	if path, err := backgroundImagePath(templatePath, backgroundPath); err == nil {
+		background := m.Hash(path)
+		inputs.Background = &background
+	}
+	if overlayPaths != "" {
+		for _, overlay := range strings.Split(overlayPaths, ",") {
+			inputs.Overlays = append(inputs.Overlays, m.Hash(overlay))
+		}
+	}
+	for _, speakerImage := range record.SpeakerImages {
+		inputs.SpeakerImages = append(inputs.SpeakerImages, m.Hash(speakerImage))
+	}
+	return inputs
+}
+
+// fileExists reports whether path exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// encodeOptions returns the settings used to save output images
+func (o renderOptions) encodeOptions() imageio.EncodeOptions {
+	return imageio.EncodeOptions{Format: o.Format, Quality: o.Quality, Subsampling: o.Subsampling}
+}
 
-	if err := renderSpeakerPair(&textRenderer, rgbaFinalImage, template.Speaker2title, template.Speaker2name, imgWidth, imgHeight, lineSpacing); err != nil {
-		log.Printf("Error rendering speaker 2: %v", err)
+// applyTemplateOutput fills the encoding settings that were not set on the command line
+// from the output section of the template
+func (o *renderOptions) applyTemplateOutput(output types.OutputConfig) error {
+	if o.Quality == 0 {
+		o.Quality = output.Quality
 	}
+	if o.Subsampling == "" && output.Subsampling != "" {
+		subsampling, err := imageio.ParseSubsampling(output.Subsampling)
+		if err != nil {
+			return fmt.Errorf("template output: %w", err)
+		}
+		o.Subsampling = subsampling
+	}
+	if o.MaxBytes == 0 {
+		o.MaxBytes = output.MaxBytes
+	}
+	if o.Quality < 0 || o.Quality > 100 {
+		return fmt.Errorf("JPEG quality must be between 1 and 100, got %d", o.Quality)
+	}
+	if o.MaxBytes > 0 && o.Format != "jpeg" {
+		return fmt.Errorf("a file-size budget needs jpeg output, not %s", o.Format)
+	}
+	return nil
+}
 
-	if err := renderTextElement(&textRenderer, rgbaFinalImage, template.Sponsor, imgWidth, imgHeight, lineSpacing); err != nil {
-		log.Printf("Error rendering sponsor: %v", err)
+// applyTemplateResample fills the resampling settings that were not set on the command
+// line from the resample section of the template
+func (o *renderOptions) applyTemplateResample(resample types.ResampleConfig) error {
+	if o.Resample.Filter == "" && resample.Filter != "" {
+		filter, err := renderer.ParseResampleFilter(resample.Filter)
+		if err != nil {
+			return fmt.Errorf("template resample: %w", err)
+		}
+		o.Resample.Filter = filter
 	}
+	o.Resample.Linear = o.Resample.Linear || resample.Linear
+	if o.Resample.Sharpen == 0 {
+		o.Resample.Sharpen = resample.Sharpen
+	}
+	if o.Resample.Sharpen < 0 {
+		return fmt.Errorf("sharpening amount must not be negative, got %g", o.Resample.Sharpen)
+	}
+	return nil
+}
 
-	if err := renderTextElement(&textRenderer, rgbaFinalImage, template.Date, imgWidth, imgHeight, lineSpacing); err != nil {
-		log.Printf("Error rendering date: %v", err)
+// eventMetadata returns the metadata embedded in the image of an event
+func eventMetadata(eventData *types.EventData, config types.MetadataConfig) imageio.Metadata {
+	year := ""
+	if len(eventData.Date) >= 4 {
+		year = eventData.Date[:4]
+	}
+	copyright := config.Copyright
+	if copyright == "" && config.Creator != "" {
+		copyright = "© {year} " + config.Creator
 	}
+	copyright = strings.TrimSpace(strings.ReplaceAll(copyright, "{year}", year))
 
-	if err := renderTextElement(&textRenderer, rgbaFinalImage, template.Title, imgWidth, imgHeight, lineSpacing); err != nil {
-		log.Printf("Error rendering title: %v", err)
+	return imageio.Metadata{
+		Title:       eventData.EventTitle,
+		Description: utils.EventAltText(eventData),
+		Creator:     config.Creator,
+		Copyright:   copyright,
 	}
+}
 
+// altTextPath returns the path of the alt text sidecar file of the images saved as
+// base with the suffix of their size and an extension
+func altTextPath(base string) string {
+	return base + ".alt.txt"
+}
+
+// saveAltText writes the alt text of an event to path
+func saveAltText(path string, eventData *types.EventData) error {
+	if err := os.WriteFile(path, []byte(utils.EventAltText(eventData)+"\n"), 0644); err != nil {
+		return fmt.Errorf("error writing alt text: %w", err)
+	}
 	return nil
 }
 
+// saveOutputImage writes a rendered image, embedding the metadata of the event
+func saveOutputImage(outputPath string, img *image.RGBA, eventData *types.EventData, opts renderOptions, record *renderRecord) error {
+	data, err := encodeOutputImage(outputPath, img, eventData, opts, record)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outputPath, data, 0644)
+}
+
+// encodeOutputImage encodes a rendered image, embedding the metadata of the event. With a
+// file-size budget, the JPEG quality is lowered until the image fits, and the quality loss
+// against the lossless render is reported for name.
+func encodeOutputImage(name string, img *image.RGBA, eventData *types.EventData, opts renderOptions, record *renderRecord) ([]byte, error) {
+	if err := opts.canceled(); err != nil {
+		return nil, err
+	}
+	encodeOptions := opts.encodeOptions()
+	if opts.Metadata && eventData != nil {
+		metadata := eventMetadata(eventData, opts.MetadataConfig)
+		encodeOptions.Metadata = &metadata
+	}
+
+	if opts.MaxBytes <= 0 {
+		var buf bytes.Buffer
+		if err := imageio.Encode(&buf, img, encodeOptions); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	result, err := imageio.EncodeWithinBytes(img, encodeOptions, opts.MaxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, _, err := imageio.Decode(bytes.NewReader(result.Data))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding encoded image for comparison: %w", err)
+	}
+	ssim, err := metrics.SSIM(img, encoded)
+	if err != nil {
+		return nil, fmt.Errorf("error comparing encoded image: %w", err)
+	}
+	psnr := metrics.PSNR(img, encoded)
+
+	if !result.Fits {
+		record.warnf("%s is %d bytes at the lowest JPEG quality, over the budget of %d bytes", name, len(result.Data), opts.MaxBytes)
+	}
+	record.printf("Encoded %s: %d bytes (budget %d) at quality %d, SSIM %.4f, PSNR %.2f dB\n",
+		name, len(result.Data), opts.MaxBytes, result.Quality, ssim, psnr)
+	return result.Data, nil
+}
+
+// resolveOutputFormat determines the output format from the --format flag or, if it is empty,
+// from the extension of the --output path, defaulting to JPEG
+func resolveOutputFormat(format, outputPath string) (string, error) {
+	if format == "" {
+		format = imageio.FormatFromPath(outputPath)
+	}
+	if format == "" {
+		return "jpeg", nil
+	}
+	return imageio.ParseFormat(format)
+}
+
+// imageRenderer returns an image renderer configured from the render options,
+// logging to the given record
+func (o renderOptions) imageRenderer(record *renderRecord) renderer.ImageRenderer {
+	return renderer.ImageRenderer{
+		SmartCrop: o.SmartCrop,
+		DebugCrop: o.DebugCrop,
+		Report:    o.Report,
+		Images:    o.Images,
+		Logger:    record.log(),
+		Resample:  o.Resample,
+		Context:   o.Context,
+	}
+}
+
+// printImageReport lists the speaker images whose EXIF orientation was corrected during the run
+func printImageReport(report *imageio.Report) {
+	corrections := report.Corrections()
+	if len(corrections) == 0 {
+		return
+	}
+	// Images are corrected in scheduling order when rendering in parallel
+	sort.Slice(corrections, func(i, j int) bool { return corrections[i].Path < corrections[j].Path })
+	fmt.Printf("Auto-corrected EXIF orientation of %d speaker image(s):\n", len(corrections))
+	for _, c := range corrections {
+		fmt.Printf("  %s (orientation %d)\n", c.Path, c.Orientation)
+	}
+}
+
 // processImages handles background and overlay image processing
-func processImages(background image.Image, overlayPaths string) (*image.RGBA, error) {
+func processImages(background image.Image, overlayPaths string, imgRenderer renderer.ImageRenderer) (*image.RGBA, error) {
 	rgbaBackground := image.NewRGBA(background.Bounds())
 	draw.Draw(rgbaBackground, rgbaBackground.Bounds(), background, image.Point{}, draw.Src)
 
@@ -81,8 +407,7 @@ func processImages(background image.Image, overlayPaths string) (*image.RGBA, er
 		overlays = strings.Split(overlayPaths, ",")
 	}
 
-	// Create image renderer and overlay images
-	imgRenderer := renderer.ImageRenderer{}
+	// Overlay images
 	finalImage, err := imgRenderer.OverlayImages(rgbaBackground, overlays)
 	if err != nil {
 		return nil, fmt.Errorf("error rendering background and overlays: %w", err)
@@ -97,26 +422,26 @@ func processImages(background image.Image, overlayPaths string) (*image.RGBA, er
 	return rgbaFinalImage, nil
 }
 
-// loadBackgroundImage loads background image from template or CLI argument
-func loadBackgroundImage(templatePath, backgroundPath string) (image.Image, error) {
+// backgroundImagePath returns the background image of the template, or the
+// --background path if no template is provided
+func backgroundImagePath(templatePath, backgroundPath string) (string, error) {
 	if templatePath != "" {
 		template, err := loadTemplate(templatePath)
 		if err != nil {
-			return nil, fmt.Errorf("error loading template: %w", err)
+			return "", fmt.Errorf("error loading template: %w", err)
 		}
 
-		backgroundPathToUse := template.Background.Image
-		if backgroundPathToUse == "" {
-			return nil, fmt.Errorf("no background image specified in template.json")
+		if template.Background.Image == "" {
+			return "", fmt.Errorf("no background image specified in template.json")
 		}
-		return utils.LoadImage(backgroundPathToUse)
+		return template.Background.Image, nil
 	}
 
 	// Fallback: use CLI backgroundPath if no template is provided
 	if backgroundPath == "" {
-		return nil, fmt.Errorf("no background image specified. Use --background or provide a template with a background image")
+		return "", fmt.Errorf("no background image specified. Use --background or provide a template with a background image")
 	}
-	return utils.LoadImage(backgroundPath)
+	return backgroundPath, nil
 }
 
 // checkTemplatesDirectory checks if templates directory exists and loads available templates
@@ -134,32 +459,70 @@ func checkTemplatesDirectory() {
 	}
 }
 
-// setupOutputPath creates artifacts directory and determines final output path
-func setupOutputPath(outputPath string, eventID string, width int) (string, error) {
+// setupOutputPaths creates artifacts directory and determines the output path of each size.
+// Without an output path, images are named {id}.ext and {id}-{size}.ext in artifacts/. An
+// output path is used as is for the full size or a single size; other sizes get their
+// suffix inserted before the extension.
+func setupOutputPaths(outputPath string, eventID string, sizes []outputSize, extension string) ([]outputTarget, error) {
 	artifactsDir := "artifacts"
 	if _, err := os.Stat(artifactsDir); os.IsNotExist(err) {
 		if err := os.MkdirAll(artifactsDir, 0755); err != nil {
-			return "", fmt.Errorf("error creating artifacts directory: %w", err)
+			return nil, fmt.Errorf("error creating artifacts directory: %w", err)
 		}
 	}
 
-	finalOutputPath := outputPath
-	if finalOutputPath == "" {
-		if width > 0 {
-			finalOutputPath = fmt.Sprintf("%s/%s-%d.jpg", artifactsDir, eventID, width)
-		} else {
-			finalOutputPath = artifactsDir + "/" + eventID + ".jpg"
-		}
-	} else if !strings.Contains(finalOutputPath, "/") && !strings.HasPrefix(finalOutputPath, ".") {
+	if outputPath == "" {
+		return eventTargets(artifactsDir, eventID, sizes, extension), nil
+	}
+	if !strings.Contains(outputPath, "/") && !strings.HasPrefix(outputPath, ".") {
 		// If only a filename is given, save it in artifacts/
-		finalOutputPath = artifactsDir + "/" + finalOutputPath
+		outputPath = artifactsDir + "/" + outputPath
 	}
 
-	return finalOutputPath, nil
+	ext := filepath.Ext(outputPath)
+	targets := make([]outputTarget, 0, len(sizes))
+	for _, size := range sizes {
+		path := outputPath
+		if len(sizes) > 1 {
+			path = sizedPath(strings.TrimSuffix(outputPath, ext), size, ext)
+		}
+		targets = append(targets, outputTarget{Size: size, Path: path, AltText: altTextPath(strings.TrimSuffix(outputPath, ext))})
+	}
+	return targets, nil
+}
+
+// templateCache holds parsed templates by path, so that a batch parses each template once
+var templateCache = struct {
+	sync.Mutex
+	templates map[string]*types.Template
+}{templates: make(map[string]*types.Template)}
+
+// forgetTemplates empties the template cache, so that templates edited since they were
+// loaded are parsed again
+func forgetTemplates() {
+	templateCache.Lock()
+	defer templateCache.Unlock()
+	clear(templateCache.templates)
+}
+
+// forgetTemplate drops the template at templatePath from the template cache, so that
+// it is parsed again
+func forgetTemplate(templatePath string) {
+	templateCache.Lock()
+	defer templateCache.Unlock()
+	delete(templateCache.templates, templatePath)
 }
 
 // loadTemplate loads and parses a template file
+// Each call returns its own deep copy, since event data is applied to the template's text elements.
 func loadTemplate(templatePath string) (*types.Template, error) {
+	templateCache.Lock()
+	cached, ok := templateCache.templates[templatePath]
+	templateCache.Unlock()
+	if ok {
+		return cached.Clone(), nil
+	}
+
 	templateData, err := os.ReadFile(templatePath)
 	if err != nil {
 		return nil, fmt.Errorf("error reading template file: %w", err)
@@ -170,7 +533,10 @@ func loadTemplate(templatePath string) (*types.Template, error) {
 		return nil, fmt.Errorf("error parsing template JSON: %w", err)
 	}
 
-	return &template, nil
+	templateCache.Lock()
+	templateCache.templates[templatePath] = &template
+	templateCache.Unlock()
+	return template.Clone(), nil
 }
 
 // loadEventsData loads events data from either a local file or remote URL
@@ -226,29 +592,8 @@ func loadEventData(eventID string, eventsFile string) (*types.EventData, error)
 			// Update the event with processed speaker image paths
 			event = singleEventSlice[0]
 
-			eventData := &types.EventData{}
-
-			if len(event.Talks) > 0 {
-				eventData.Speaker1Title = event.Talks[0].Title
-				eventData.Speaker1Name = event.Talks[0].Speaker
-				eventData.Speaker1Image = event.Talks[0].Image
-			}
-			if len(event.Talks) > 1 {
-				eventData.Speaker2Title = event.Talks[1].Title
-				eventData.Speaker2Name = event.Talks[1].Speaker
-				eventData.Speaker2Image = event.Talks[1].Image
-			}
-			if event.Host != "" {
-				eventData.Sponsor = event.Host
-			}
-			if event.Date != "" {
-				eventData.Date = event.Date
-			}
-			if event.Title != "" {
-				eventData.EventTitle = event.Title
-			}
-
-			return eventData, nil
+			eventData := eventToEventData(event)
+			return &eventData, nil
 		}
 	}
 
@@ -276,174 +621,677 @@ func loadAllEvents(eventsFile string) ([]types.EventData, error) {
 
 	var allEventData []types.EventData
 	for _, event := range events {
-		eventData := types.EventData{}
+		allEventData = append(allEventData, eventToEventData(event))
+	}
+
+	return allEventData, nil
+}
+
+// eventToEventData converts an event from events.yml into the data used for rendering
+func eventToEventData(event types.Event) types.EventData {
+	eventData := types.EventData{
+		Talks: event.Talks,
+	}
+	if event.Host != "" {
+		eventData.Sponsor = event.Host
+	}
+	if event.Date != "" {
+		eventData.Date = event.Date
+	}
+	if event.Title != "" {
+		eventData.EventTitle = event.Title
+	}
+
+	// Store the event ID for filename generation
+	eventData.Title = fmt.Sprintf("%d", event.ID)
+
+	return eventData
+}
+
+// resolveTalkSlots selects the template layout matching the number of talks and fills in the talk text
+func resolveTalkSlots(template *types.Template, eventData *types.EventData, record *renderRecord) []types.TalkSlot {
+	var talks []types.Talk
+	if eventData != nil {
+		talks = eventData.Talks
+	}
+
+	layout := templates.SelectLayout(template, len(talks))
+	if len(talks) > len(layout.Slots) {
+		record.warnf("Template layout has %d talk slots but event %s has %d talks; extra talks are not rendered", len(layout.Slots), eventData.Title, len(talks))
+	}
+
+	for i := range layout.Slots {
+		if i >= len(talks) {
+			break
+		}
+		if talks[i].Title != "" {
+			layout.Slots[i].Title.Text = talks[i].Title
+		}
+		if names := speakerNamesText(talks[i]); names != "" {
+			layout.Slots[i].Name.Text = names
+		}
+	}
+
+	return layout.Slots
+}
+
+// speakerNamesText returns the speaker names of a talk as a stacked list, one speaker per line
+func speakerNamesText(talk types.Talk) string {
+	var lines []string
+	for _, speaker := range utils.TalkSpeakers(talk) {
+		line := speaker.Name
+		if speaker.Company != "" {
+			line = fmt.Sprintf("%s (%s)", speaker.Name, speaker.Company)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// renderPlan holds everything an event image is rendered from. It is resolved before
+// rendering so that the inputs can be fingerprinted and unchanged outputs skipped.
+type renderPlan struct {
+	EventData      *types.EventData
+	TemplatePath   string
+	Template       *types.Template
+	Slots          []types.TalkSlot
+	BackgroundPath string
+	OverlayPaths   string
+	SpeakerImages  [][]renderer.SpeakerImage
+	ImageElements  []types.ImageElement
+}
+
+// outputSize is a size an event image is saved in: a resize spec, or a preset, which
+// renders the template variant for the preset and fills the preset size with it
+type outputSize struct {
+	Spec   renderer.ResizeSpec
+	Preset string
+}
+
+// suffix returns the part of the output file name that identifies the size
+func (s outputSize) suffix() string {
+	if s.Preset != "" {
+		return s.Preset
+	}
+	return s.Spec.Suffix()
+}
+
+// variant returns the manifest variant name of the size
+func (s outputSize) variant() string {
+	if s.Preset != "" {
+		return s.Preset
+	}
+	return outputVariant(s.Spec)
+}
+
+// presetSize returns the output size of a preset
+func presetSize(preset templates.Preset) outputSize {
+	spec := renderer.ResizeSpec{Mode: renderer.ResizeFill, Width: preset.Width, Height: preset.Height, Density: 1}
+	return outputSize{Spec: spec, Preset: preset.Name}
+}
 
-		if len(event.Talks) > 0 {
-			eventData.Speaker1Title = event.Talks[0].Title
-			eventData.Speaker1Name = event.Talks[0].Speaker
-			eventData.Speaker1Image = event.Talks[0].Image
+// outputTarget is one size of an event image and the path it is saved to
+type outputTarget struct {
+	Size outputSize
+	Path string
+	// AltText is the path of the alt text of the event, shared by all its sizes
+	AltText string
+}
+
+// targetStatus is the outcome of generating an output target
+type targetStatus int
+
+const (
+	targetFailed targetStatus = iota
+	targetGenerated
+	targetSkipped
+)
+
+// parseSizes parses a comma-separated list of resize specs and preset names
+func parseSizes(list string) ([]outputSize, error) {
+	var sizes []outputSize
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
 		}
-		if len(event.Talks) > 1 {
-			eventData.Speaker2Title = event.Talks[1].Title
-			eventData.Speaker2Name = event.Talks[1].Speaker
-			eventData.Speaker2Image = event.Talks[1].Image
+		if preset, err := templates.LookupPreset(field); err == nil {
+			sizes = append(sizes, presetSize(preset))
+			continue
 		}
-		if event.Host != "" {
-			eventData.Sponsor = event.Host
+		spec, err := renderer.ParseResizeSpec(field)
+		if err != nil {
+			return nil, err
 		}
-		if event.Date != "" {
-			eventData.Date = event.Date
+		sizes = append(sizes, outputSize{Spec: spec})
+	}
+	return sizes, nil
+}
+
+// outputSizes returns the sizes to render without duplicates: the --width, --size and
+// --preset lists if given, else the outputs list of the template, else only the full size
+func outputSizes(widthFlag, sizeFlag, presetFlag string, template *types.Template) ([]outputSize, error) {
+	sizes, err := parseSizes(widthFlag)
+	if err != nil {
+		return nil, err
+	}
+	for _, size := range sizes {
+		if size.Preset != "" || (size.Spec.Mode != renderer.ResizeNone && size.Spec.Mode != renderer.ResizeWidth) {
+			return nil, fmt.Errorf("--width only takes widths, use --size for %q", size.suffix())
 		}
-		if event.Title != "" {
-			eventData.EventTitle = event.Title
+	}
+	more, err := parseSizes(sizeFlag)
+	if err != nil {
+		return nil, err
+	}
+	sizes = append(sizes, more...)
+	presets, err := templates.ParsePresets(presetFlag)
+	if err != nil {
+		return nil, err
+	}
+	for _, preset := range presets {
+		sizes = append(sizes, presetSize(preset))
+	}
+
+	if len(sizes) == 0 && template != nil {
+		for _, output := range template.Outputs {
+			more, err := parseSizes(string(output))
+			if err != nil {
+				return nil, fmt.Errorf("template outputs: %w", err)
+			}
+			sizes = append(sizes, more...)
 		}
+	}
+	if len(sizes) == 0 {
+		return []outputSize{{Spec: renderer.ResizeSpec{Density: 1}}}, nil
+	}
 
-		// Store the event ID for filename generation
-		eventData.Title = fmt.Sprintf("%d", event.ID)
+	// Sizes that only differ in their letterbox color would be saved to the same file
+	var unique []outputSize
+	suffixes := map[string]outputSize{}
+	for _, size := range sizes {
+		if previous, ok := suffixes[size.suffix()]; ok {
+			if previous != size {
+				return nil, fmt.Errorf("sizes %q and %q would be saved to the same file", previous.Spec, size.Spec)
+			}
+			continue
+		}
+		suffixes[size.suffix()] = size
+		unique = append(unique, size)
+	}
+	return unique, nil
+}
 
-		allEventData = append(allEventData, eventData)
+// sizedPath returns the path of an output of the given size: base for the full size,
+// base-{suffix} for other sizes and presets, and base@2x for densities of the full size
+func sizedPath(base string, size outputSize, extension string) string {
+	suffix := size.suffix()
+	if suffix != "" && !strings.HasPrefix(suffix, "@") {
+		suffix = "-" + suffix
 	}
+	return base + suffix + extension
+}
 
-	return allEventData, nil
+// eventTargets returns the output targets of an event in outputDir, named {id}.ext
+// for the full size and e.g. {id}-550.ext or {id}-linkedin.ext for other sizes
+func eventTargets(outputDir, eventID string, sizes []outputSize, extension string) []outputTarget {
+	targets := make([]outputTarget, 0, len(sizes))
+	for _, size := range sizes {
+		path := sizedPath(outputDir+"/"+eventID, size, extension)
+		targets = append(targets, outputTarget{Size: size, Path: path, AltText: altTextPath(outputDir + "/" + eventID)})
+	}
+	return targets
 }
 
-// generateImageForEvent generates an image for a single event
-func generateImageForEvent(eventData *types.EventData, templatePath, backgroundPath, overlayPaths, outputDir string, width int) error {
-	// Load background image
-	background, err := loadBackgroundImage(templatePath, backgroundPath)
+// variantTemplatePath returns the template to render a size with: for presets, the variant
+// the template declares for the preset, or the template itself if there is none. Presets
+// without a variant are cropped from the main layout, which is logged if their aspect
+// ratio differs noticeably from the canvas of the template.
+func variantTemplatePath(templatePath string, size outputSize, record *renderRecord) string {
+	if templatePath == "" || size.Preset == "" {
+		return templatePath
+	}
+	template, err := loadTemplate(templatePath)
 	if err != nil {
-		return fmt.Errorf("error loading background image: %w", err)
+		return templatePath
+	}
+	if variant := template.Variants[size.Preset]; variant != "" {
+		return variant
 	}
 
-	// Process background and overlay images
-	rgbaFinalImage, err := processImages(background, overlayPaths)
+	canvas := template.Background.Canvas
+	if canvas.Width <= 0 || canvas.Height <= 0 {
+		canvas = template.Background.Size
+	}
+	if canvas.Width > 0 && canvas.Height > 0 {
+		ratio := float64(size.Spec.Width) * float64(canvas.Height) / (float64(size.Spec.Height) * float64(canvas.Width))
+		if ratio < 0.95 || ratio > 1.05 {
+			record.warnf("No %s variant in %s, cropping the %dx%d layout to %dx%d", size.Preset, templatePath, canvas.Width, canvas.Height, size.Spec.Width, size.Spec.Height)
+		}
+	}
+	return templatePath
+}
+
+// generateTargets generates the targets of an event. Targets are grouped by the template
+// they are rendered from, so each template variant is composed once. It returns the
+// status of each target and the first error; the targets of other templates are
+// still generated after an error.
+func generateTargets(eventData *types.EventData, templatePath, backgroundPath, overlayPaths string, targets []outputTarget, opts renderOptions, record *renderRecord) ([]targetStatus, error) {
+	statuses := make([]targetStatus, len(targets))
+	var order []string
+	groups := map[string][]int{}
+	for i, target := range targets {
+		path := variantTemplatePath(templatePath, target.Size, record)
+		if _, ok := groups[path]; !ok {
+			order = append(order, path)
+		}
+		groups[path] = append(groups[path], i)
+	}
+
+	eventID := ""
+	if eventData != nil {
+		eventID = eventData.Title
+	}
+	var firstErr error
+	for _, path := range order {
+		indices := groups[path]
+		group := make([]outputTarget, len(indices))
+		for j, i := range indices {
+			group[j] = targets[i]
+		}
+
+		plan, err := planEventImage(eventData, path, backgroundPath, overlayPaths, record)
+		if err == nil {
+			var groupStatuses []targetStatus
+			groupStatuses, err = plan.generateOutputs(group, opts, record)
+			for j, i := range indices {
+				statuses[i] = groupStatuses[j]
+			}
+		} else {
+			for _, target := range group {
+				recordOutput(opts.Manifest, eventID, target, nil, path, backgroundPath, overlayPaths, record, "", err)
+			}
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return statuses, firstErr
+}
+
+// planEventImage loads the template and resolves the layout, text and speaker images for an event.
+// Speaker images and warnings are collected in record, which may be nil.
+func planEventImage(eventData *types.EventData, templatePath, backgroundPath, overlayPaths string, record *renderRecord) (*renderPlan, error) {
+	path, err := backgroundImagePath(templatePath, backgroundPath)
 	if err != nil {
-		return fmt.Errorf("error processing images: %w", err)
+		return nil, fmt.Errorf("error loading background image: %w", err)
+	}
+
+	plan := &renderPlan{
+		EventData:      eventData,
+		TemplatePath:   templatePath,
+		BackgroundPath: path,
+		OverlayPaths:   overlayPaths,
 	}
 
 	// Load template if provided
-	var template *types.Template
 	if templatePath != "" {
-		tmpl, err := loadTemplate(templatePath)
+		template, err := loadTemplate(templatePath)
 		if err != nil {
-			return fmt.Errorf("error loading template: %w", err)
+			return nil, fmt.Errorf("error loading template: %w", err)
+		}
+		plan.Template = template
+		plan.Slots = resolveTalkSlots(template, eventData, record)
+		if eventData != nil {
+			applyEventDataToTemplate(template, eventData)
+			plan.SpeakerImages, plan.ImageElements = resolveSpeakerImages(eventData, plan.Slots, record)
 		}
-		template = tmpl
+		images, elements := placedImages(template, record)
+		plan.SpeakerImages = append(plan.SpeakerImages, images...)
+		plan.ImageElements = append(plan.ImageElements, elements...)
 	}
 
-	// Render text using template if provided
-	if err := renderTextFromTemplate(templatePath, eventData, rgbaFinalImage, template); err != nil {
-		return fmt.Errorf("error rendering text: %w", err)
+	return plan, nil
+}
+
+// placedImages returns the fixed images of a template, each drawn with its own element.
+// Their sources are collected in record, which may be nil.
+func placedImages(template *types.Template, record *renderRecord) ([][]renderer.SpeakerImage, []types.ImageElement) {
+	var images [][]renderer.SpeakerImage
+	var elements []types.ImageElement
+	for _, placed := range template.Images {
+		if placed.Source == "" {
+			continue
+		}
+		if record != nil {
+			record.SpeakerImages = append(record.SpeakerImages, placed.Source)
+		}
+		images = append(images, []renderer.SpeakerImage{{Path: placed.Source}})
+		elements = append(elements, placed.ImageElement)
 	}
+	return images, elements
+}
 
-	// Add speaker images if available
-	if eventData != nil && template != nil {
-		if err := addSpeakerImages(rgbaFinalImage, eventData, template); err != nil {
-			log.Printf("Warning: Error adding speaker images for event %s: %v", eventData.Title, err)
+// render composes the background, overlays, text and speaker images of the plan at full size
+func (p *renderPlan) render(opts renderOptions, record *renderRecord) (*image.RGBA, error) {
+	// Load background image
+	background, _, err := opts.Images.Load(p.BackgroundPath)
+	if err != nil {
+		return nil, fmt.Errorf("error loading background image: %w", err)
+	}
+
+	// Place the background on the canvas of the template
+	if p.Template != nil {
+		imgRenderer := opts.imageRenderer(record)
+		background = imgRenderer.ComposeBackground(background, p.Template.Background)
+	}
+
+	// A transparent canvas keeps the background size but not its pixels, so the
+	// rendered elements can be exported as a layer
+	if opts.Transparent {
+		background = image.NewRGBA(background.Bounds())
+	}
+
+	// Process background and overlay images
+	rgbaFinalImage, err := processImages(background, p.OverlayPaths, opts.imageRenderer(record))
+	if err != nil {
+		return nil, fmt.Errorf("error processing images: %w", err)
+	}
+	if err := opts.canceled(); err != nil {
+		return nil, err
+	}
+
+	// Render text using template if provided, in colors contrasting with the background
+	var blocks []textBlock
+	var contrastIssues []layoutIssue
+	width, height := rgbaFinalImage.Bounds().Dx(), rgbaFinalImage.Bounds().Dy()
+	if p.TemplatePath != "" && p.Template != nil {
+		blocks, err = layoutTemplateText(p.Template, p.Slots, width, height, record.log())
+		if err == nil {
+			if !opts.Transparent {
+				contrastIssues = p.adjustContrast(rgbaFinalImage, blocks, record)
+			}
+			err = drawTextBlocks(rgbaFinalImage, blocks)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error rendering text: %w", err)
 		}
 	}
 
-	// Resize to width if requested (keep aspect ratio)
-	if width > 0 {
-		ir := renderer.ImageRenderer{}
-		resized := ir.ResizeKeepAspect(rgbaFinalImage, width)
-		// Ensure rgbaFinalImage references the resized image for downstream save
-		if r, ok := resized.(*image.RGBA); ok {
-			rgbaFinalImage = r
-		} else {
-			// Convert to RGBA if needed
-			tmp := image.NewRGBA(resized.Bounds())
-			draw.Draw(tmp, tmp.Bounds(), resized, image.Point{}, draw.Src)
-			rgbaFinalImage = tmp
+	// Add speaker images if available
+	if err := opts.canceled(); err != nil {
+		return nil, err
+	}
+	if len(p.SpeakerImages) > 0 {
+		imgRenderer := opts.imageRenderer(record)
+		if err := imgRenderer.OverlaySpeakerImages(rgbaFinalImage, p.SpeakerImages, p.ImageElements); err != nil {
+			record.warnf("Error adding images for %s: %v", p.name(), err)
 		}
 	}
 
-	// Determine output path
-	finalOutputPath := fmt.Sprintf("%s/%s.jpg", outputDir, eventData.Title)
-	if width > 0 {
-		finalOutputPath = fmt.Sprintf("%s/%s-%d.jpg", outputDir, eventData.Title, width)
+	if err := opts.canceled(); err != nil {
+		return nil, err
+	}
+	avatars := p.avatarBlocks(width, height)
+	if err := p.lint(blocks, avatars, contrastIssues, rgbaFinalImage.Bounds(), opts, record); err != nil {
+		return nil, err
+	}
+	if opts.DebugLayout {
+		drawLayoutDebug(rgbaFinalImage, blocks, avatars)
 	}
 
-	// Save final image
-	err = utils.SaveImage(finalOutputPath, rgbaFinalImage)
-	if err != nil {
-		return fmt.Errorf("error saving final image: %w", err)
+	return rgbaFinalImage, nil
+}
+
+// name returns the event ID of the plan, or its template path if it renders no event
+func (p *renderPlan) name() string {
+	if p.EventData != nil {
+		return "event " + p.EventData.Title
 	}
+	return p.TemplatePath
+}
 
-	fmt.Printf("Image generated successfully for event %s: %s\n", eventData.Title, finalOutputPath)
-	return nil
+// resize resamples the composition of the plan to size with the resampling settings
+// of opts. Fill sizes keep the focus point of the template in view.
+func (p *renderPlan) resize(img *image.RGBA, spec renderer.ResizeSpec, opts renderOptions) *image.RGBA {
+	var focus *types.FocalPoint
+	if p.Template != nil {
+		focus = p.Template.Focus
+	}
+	ir := renderer.ImageRenderer{Resample: opts.Resample}
+	return ir.Resize(img, spec, focus)
 }
 
-// renderTextElement renders a text element with proper wrapping and positioning
-func renderTextElement(textRenderer *renderer.TextRenderer, img *image.RGBA, element types.TextElement, imgWidth, imgHeight int, lineSpacing float64) error {
-	boxX := int(element.Position.X * float64(imgWidth))
-	boxY := int(element.Position.Y * float64(imgHeight))
-	boxWidth := int(element.BoxWidth * float64(imgWidth))
+// generateOutputs renders the plan once and saves it in the size of every target.
+// With a manifest, targets whose inputs are unchanged since the last run are skipped,
+// and nothing is rendered if all of them are. Every saved or failed target is recorded
+// in the manifest. It returns the status of each target.
+func (p *renderPlan) generateOutputs(targets []outputTarget, opts renderOptions, record *renderRecord) ([]targetStatus, error) {
+	statuses := make([]targetStatus, len(targets))
+	fingerprints := make([]string, len(targets))
+	stale := 0
+	for i, target := range targets {
+		if opts.Manifest != nil {
+			fingerprint, err := p.fingerprint(opts, target.Size.Spec)
+			if err != nil {
+				return statuses, fmt.Errorf("error fingerprinting inputs: %w", err)
+			}
+			fingerprints[i] = fingerprint
+			if !opts.Force {
+				if output, ok := opts.Manifest.Unchanged(target.Path, fingerprint); ok {
+					opts.Manifest.Add(output)
+					statuses[i] = targetSkipped
+					continue
+				}
+			}
+		}
+		stale++
+	}
+	if stale == 0 {
+		return statuses, nil
+	}
 
-	font := loadFont(element.Font)
-	wrappedText := wrapText(element.Text, boxWidth, font, element.FontSize)
+	composition, err := p.render(opts, record)
+	if err != nil {
+		for i, target := range targets {
+			if statuses[i] != targetSkipped {
+				p.recordOutput(opts.Manifest, target, nil, record, fingerprints[i], err)
+			}
+		}
+		return statuses, err
+	}
 
-	for i, line := range wrappedText {
-		y := boxY + int(float64(i)*element.FontSize*lineSpacing)
-		err := textRenderer.RenderTextWithPositionAndColor(img, line, element.Font, element.FontSize, element.Color, boxX, y)
-		if err != nil {
-			return fmt.Errorf("error rendering text: %w", err)
+	// The alt text is the same for all sizes, so it is written once for the event
+	altTextSaved := false
+	for i, target := range targets {
+		if statuses[i] == targetSkipped {
+			continue
+		}
+		if opts.Metadata && p.EventData != nil && !altTextSaved {
+			if err := saveAltText(target.AltText, p.EventData); err != nil {
+				p.recordOutput(opts.Manifest, target, nil, record, fingerprints[i], err)
+				return statuses, err
+			}
+			altTextSaved = true
+		}
+		img := p.resize(composition, target.Size.Spec, opts)
+		if err := saveOutputImage(target.Path, img, p.EventData, opts, record); err != nil {
+			err = fmt.Errorf("error saving %s: %w", target.Path, err)
+			p.recordOutput(opts.Manifest, target, nil, record, fingerprints[i], err)
+			return statuses, err
 		}
+		p.recordOutput(opts.Manifest, target, img, record, fingerprints[i], nil)
+		statuses[i] = targetGenerated
 	}
+	return statuses, nil
+}
 
-	return nil
+// recordOutput adds a target of the plan to the build manifest
+func (p *renderPlan) recordOutput(m *manifest.Manifest, target outputTarget, img image.Image, record *renderRecord, fingerprint string, renderErr error) {
+	eventID := ""
+	if p.EventData != nil {
+		eventID = p.EventData.Title
+	}
+	recordOutput(m, eventID, target, img, p.TemplatePath, p.BackgroundPath, p.OverlayPaths, record, fingerprint, renderErr)
 }
 
-// renderSpeakerPair renders both title and name for a speaker with proper spacing
-func renderSpeakerPair(textRenderer *renderer.TextRenderer, img *image.RGBA, titleElement, nameElement types.TextElement, imgWidth, imgHeight int, lineSpacing float64) error {
-	// Calculate positions
-	titleBoxX := int(titleElement.Position.X * float64(imgWidth))
-	titleBoxY := int(titleElement.Position.Y * float64(imgHeight))
-	titleBoxWidth := int(titleElement.BoxWidth * float64(imgWidth))
-	nameBoxWidth := int(nameElement.BoxWidth * float64(imgWidth))
-
-	// Load fonts and wrap text
-	titleFont := loadFont(titleElement.Font)
-	nameFont := loadFont(nameElement.Font)
-	wrappedTitle := wrapText(titleElement.Text, titleBoxWidth, titleFont, titleElement.FontSize)
-	wrappedName := wrapText(nameElement.Text, nameBoxWidth, nameFont, nameElement.FontSize)
-
-	// Render title
-	for i, line := range wrappedTitle {
-		y := titleBoxY + int(float64(i)*titleElement.FontSize*lineSpacing)
-		err := textRenderer.RenderTextWithPositionAndColor(img, line, titleElement.Font, titleElement.FontSize, titleElement.Color, titleBoxX, y)
-		if err != nil {
-			return fmt.Errorf("error rendering title: %w", err)
+// inputFiles returns the paths of the template file, images and fonts the plan renders
+func (p *renderPlan) inputFiles() []string {
+	var files []string
+	addFile := func(path string) {
+		if path != "" {
+			files = append(files, path)
 		}
 	}
-
-	// Render name below title with spacing
-	nameStartY := titleBoxY + int(float64(len(wrappedTitle))*titleElement.FontSize*lineSpacing) + int(nameElement.FontSize*0.5)
-	for i, line := range wrappedName {
-		y := nameStartY + int(float64(i)*nameElement.FontSize*lineSpacing)
-		err := textRenderer.RenderTextWithPositionAndColor(img, line, nameElement.Font, nameElement.FontSize, nameElement.Color, titleBoxX, y)
-		if err != nil {
-			return fmt.Errorf("error rendering name: %w", err)
+	// Render requests name their template, which is not read from a file
+	if p.Template != nil && fileExists(p.TemplatePath) {
+		addFile(p.TemplatePath)
+	}
+	addFile(p.BackgroundPath)
+	if p.OverlayPaths != "" {
+		for _, overlay := range strings.Split(p.OverlayPaths, ",") {
+			addFile(overlay)
 		}
 	}
-
-	return nil
+	for _, group := range p.SpeakerImages {
+		for _, speakerImage := range group {
+			addFile(speakerImage.Path)
+		}
+	}
+	if p.Template != nil {
+		for _, element := range []types.TextElement{p.Template.Sponsor, p.Template.Date, p.Template.Title} {
+			addFile(element.Font)
+		}
+		for _, slot := range p.Slots {
+			addFile(slot.Title.Font)
+			addFile(slot.Name.Font)
+		}
+		for _, element := range p.Template.Texts {
+			addFile(element.Font)
+		}
+	}
+	return files
 }
 
-// applyEventDataToTemplate applies event data to template, overriding text fields
-func applyEventDataToTemplate(template *types.Template, eventData *types.EventData) {
-	if eventData.Speaker1Title != "" {
-		template.Speaker1title.Text = eventData.Speaker1Title
+// fingerprint returns a hash of everything that affects the output at size: the
+// generator version, event record, resolved template, fonts, images and settings.
+// File contents are hashed through the manifest, which caches them for the run.
+func (p *renderPlan) fingerprint(opts renderOptions, spec renderer.ResizeSpec) (string, error) {
+	files := map[string]string{}
+	for _, path := range p.inputFiles() {
+		files[path] = opts.Manifest.Hash(path).SHA256
+	}
+
+	// The list of sizes does not affect the image of one size, but the focus does
+	var template *types.Template
+	if p.Template != nil {
+		copied := *p.Template
+		copied.Outputs = nil
+		template = &copied
+	}
+
+	data, err := json.Marshal(struct {
+		Version       string
+		Event         *types.EventData
+		Template      *types.Template
+		Slots         []types.TalkSlot
+		SpeakerImages [][]renderer.SpeakerImage
+		Files         map[string]string
+		Size          string
+		Options       renderOptions
+	}{generatorVersion, p.EventData, template, p.Slots, p.SpeakerImages, files, spec.String(), opts})
+	if err != nil {
+		return "", err
 	}
-	if eventData.Speaker1Name != "" {
-		template.Speaker1name.Text = eventData.Speaker1Name
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// generateImageForEvent generates the images of a single event in all sizes, logging to record
+// It returns the number of images generated and skipped because their inputs are unchanged since the last run.
+func generateImageForEvent(eventData *types.EventData, templatePath, backgroundPath, overlayPaths, outputDir string, sizes []outputSize, opts renderOptions, record *renderRecord) (int, int, error) {
+	targets := eventTargets(outputDir, eventData.Title, sizes, imageio.OutputExtension(opts.Format))
+	statuses, err := generateTargets(eventData, templatePath, backgroundPath, overlayPaths, targets, opts, record)
+
+	generatedCount, skippedCount := 0, 0
+	for i, target := range targets {
+		switch statuses[i] {
+		case targetSkipped:
+			skippedCount++
+			record.printf("Skipping event %s: %s is up to date\n", eventData.Title, target.Path)
+		case targetGenerated:
+			generatedCount++
+			record.printf("Image generated successfully for event %s: %s\n", eventData.Title, target.Path)
+		}
 	}
-	if eventData.Speaker2Title != "" {
-		template.Speaker2title.Text = eventData.Speaker2Title
+	return generatedCount, skippedCount, err
+}
+
+// batchResult is the outcome of generating the image of one event in a batch
+type batchResult struct {
+	record    *bufferedRecord
+	generated int
+	skipped   int
+	err       error
+}
+
+// generateAllImages generates the images of all events with up to jobs workers.
+// Each event logs into its own buffer, and the buffers are flushed in event order as
+// soon as all earlier events are done, so the output does not depend on scheduling.
+// It returns the number of generated and skipped images.
+func generateAllImages(allEventData []types.EventData, templatePath, backgroundPath, overlayPaths, outputDir string, sizes []outputSize, opts renderOptions, jobs int) (int, int) {
+	if jobs < 1 {
+		jobs = 1
+	}
+	if jobs > len(allEventData) {
+		jobs = len(allEventData)
+	}
+
+	results := make([]batchResult, len(allEventData))
+	done := make([]chan struct{}, len(allEventData))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				record := newBufferedRecord()
+				generated, skipped, err := generateImageForEvent(&allEventData[i], templatePath, backgroundPath, overlayPaths, outputDir, sizes, opts, record.renderRecord)
+				results[i] = batchResult{record: record, generated: generated, skipped: skipped, err: err}
+				close(done[i])
+			}
+		}()
 	}
-	if eventData.Speaker2Name != "" {
-		template.Speaker2name.Text = eventData.Speaker2Name
+	go func() {
+		for i := range allEventData {
+			indices <- i
+		}
+		close(indices)
+	}()
+
+	successCount := 0
+	skippedCount := 0
+	for i, eventData := range allEventData {
+		<-done[i]
+		result := results[i]
+		result.record.flush()
+		if result.err != nil {
+			log.Printf("Error generating image for event %s: %v", eventData.Title, result.err)
+		}
+		successCount += result.generated
+		skippedCount += result.skipped
 	}
+	wg.Wait()
+	return successCount, skippedCount
+}
+
+// applyEventDataToTemplate applies event data to template, overriding text fields
+func applyEventDataToTemplate(template *types.Template, eventData *types.EventData) {
 	if eventData.Sponsor != "" {
 		template.Sponsor.Text = eventData.Sponsor
 	}
@@ -461,45 +1309,88 @@ func applyEventDataToTemplate(template *types.Template, eventData *types.EventDa
 	}
 }
 
-// addSpeakerImages adds speaker images as overlays to the final image
-func addSpeakerImages(rgbaFinalImage *image.RGBA, eventData *types.EventData, template *types.Template) error {
-	imgRenderer := renderer.ImageRenderer{}
+// resolveSpeakerImages returns the speaker images of each talk shown in the layout,
+// together with the image element of its slot. Talks without images are skipped.
+func resolveSpeakerImages(eventData *types.EventData, slots []types.TalkSlot, record *renderRecord) ([][]renderer.SpeakerImage, []types.ImageElement) {
+	var speakerImages [][]renderer.SpeakerImage
+	var elements []types.ImageElement
+	for i, slot := range slots {
+		if i >= len(eventData.Talks) {
+			break
+		}
+		group := resolveTalkImages(eventData.Talks[i], eventData.Title, i+1, record)
+		if len(group) == 0 {
+			continue
+		}
+		if record != nil {
+			for _, speakerImage := range group {
+				record.SpeakerImages = append(record.SpeakerImages, speakerImage.Path)
+			}
+		}
 
-	// Resolve speaker image paths using the new logic
-	var speaker1Image, speaker2Image string
-	var err error
+		speakerImages = append(speakerImages, group)
+		elements = append(elements, slot.Image)
+	}
 
-	if eventData.Speaker1Image != "" {
-		// Extract event ID from eventData.Title (which contains the event ID)
-		eventID := eventData.Title
-		if eventIDInt := parseEventID(eventID); eventIDInt > 0 {
-			speaker1Image, err = utils.GetSpeakerImagePath(eventData.Speaker1Image, eventIDInt, 1)
-			if err != nil {
-				log.Printf("Warning: Failed to resolve speaker 1 image: %v", err)
-				speaker1Image = ""
-			}
-		} else {
-			// Fallback to original logic for non-standard event IDs
-			speaker1Image = strings.TrimPrefix(eventData.Speaker1Image, "/")
+	return speakerImages, elements
+}
+
+// resolveTalkImages returns the local speaker images for the speakers of a talk.
+// Talks listing individual speakers get one image per speaker that has one; otherwise
+// the talk image is used for all speakers.
+func resolveTalkImages(talk types.Talk, eventID string, talkID int, record *renderRecord) []renderer.SpeakerImage {
+	var images []renderer.SpeakerImage
+	for j, speaker := range talk.Speakers {
+		if speaker.Image == "" {
+			continue
+		}
+		speakerImage := resolveSpeakerImage(speaker.Image, eventID, talkID, j+1, record)
+		if speakerImage == "" {
+			continue
+		}
+
+		// Framing set on a speaker overrides the talk's framing
+		framed := renderer.SpeakerImage{Path: speakerImage, Focus: talk.ImageFocus, Zoom: talk.ImageZoom}
+		if speaker.ImageFocus != nil {
+			framed.Focus = speaker.ImageFocus
+		}
+		if speaker.ImageZoom != 0 {
+			framed.Zoom = speaker.ImageZoom
 		}
+		images = append(images, framed)
 	}
 
-	if eventData.Speaker2Image != "" {
-		// Extract event ID from eventData.Title (which contains the event ID)
-		eventID := eventData.Title
-		if eventIDInt := parseEventID(eventID); eventIDInt > 0 {
-			speaker2Image, err = utils.GetSpeakerImagePath(eventData.Speaker2Image, eventIDInt, 2)
-			if err != nil {
-				log.Printf("Warning: Failed to resolve speaker 2 image: %v", err)
-				speaker2Image = ""
-			}
-		} else {
-			// Fallback to original logic for non-standard event IDs
-			speaker2Image = strings.TrimPrefix(eventData.Speaker2Image, "/")
+	if len(images) == 0 && talk.Image != "" {
+		if speakerImage := resolveSpeakerImage(talk.Image, eventID, talkID, 0, record); speakerImage != "" {
+			images = append(images, renderer.SpeakerImage{Path: speakerImage, Focus: talk.ImageFocus, Zoom: talk.ImageZoom})
 		}
 	}
 
-	return imgRenderer.OverlaySpeakerImages(rgbaFinalImage, speaker1Image, speaker2Image, template)
+	return images
+}
+
+// resolveSpeakerImage resolves a speaker image path, downloading it if needed.
+// A speakerID of 0 refers to the talk image shared by all speakers of the talk.
+func resolveSpeakerImage(imagePath string, eventID string, talkID, speakerID int, record *renderRecord) string {
+	// The event ID is stored in eventData.Title
+	eventIDInt := parseEventID(eventID)
+	if eventIDInt <= 0 {
+		// Fallback to original logic for non-standard event IDs
+		return strings.TrimPrefix(imagePath, "/")
+	}
+
+	var speakerImage string
+	var err error
+	if speakerID > 0 {
+		speakerImage, err = utils.GetTalkSpeakerImagePath(imagePath, eventIDInt, talkID, speakerID)
+	} else {
+		speakerImage, err = utils.GetSpeakerImagePath(imagePath, eventIDInt, talkID)
+	}
+	if err != nil {
+		record.warnf("Failed to resolve speaker %d image: %v", talkID, err)
+		return ""
+	}
+	return speakerImage
 }
 
 // parseEventID converts an event ID string to integer
@@ -512,17 +1403,115 @@ func parseEventID(eventID string) int {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "serve":
+			serve(os.Args[2:])
+			return
+		case "render":
+			renderCommand(os.Args[2:])
+			return
+		case "preview":
+			preview(os.Args[2:])
+			return
+		case "diff":
+			diffCommand(os.Args[2:])
+			return
+		}
+	}
+
 	// Define command-line arguments
 	backgroundPath := flag.String("background", "", "Path to the background image")
 	overlayPaths := flag.String("overlays", "", "Comma-separated paths to overlay images")
 	outputPath := flag.String("output", "", "Path to save the final image")
 	templatePath := flag.String("template", "", "Path to the JSON template file") // Template file
 	eventID := flag.String("id", "", "ID of the event in events.yml to use for speaker/talk text")
-	width := flag.Int("width", 0, "Output image width in pixels (keeps aspect ratio)")
+	width := flag.String("width", "", "Comma-separated output widths in pixels, rendered from one composition keeping the aspect ratio; 0 is the full size (default: template outputs, else full size)")
+	size := flag.String("size", "", "Comma-separated output sizes such as 550, h=630, fit=1200x630, fill=1080x1080 or 550@2x, in addition to --width")
+	preset := flag.String("preset", "", "Comma-separated output presets, or all: "+strings.Join(templates.PresetNames(), ", "))
 	eventsFile := flag.String("file", "", "Path to local events.yml file (instead of remote URL)")
+	smartCrop := flag.Bool("smart-crop", false, "Crop speaker images based on their content instead of centering them")
+	debugCrop := flag.Bool("debug-crop", false, "Log the crop rectangle chosen for each speaker image")
+	lint := flag.String("lint", "warn", "Layout check: warn reports texts beyond the image or their box, overlapping elements and elements within the template's safe margin; error also fails images with layout errors; off skips the check")
+	debugLayout := flag.Bool("debug-layout", false, "Draw the boxes, box width limits, baselines, wrap points and anchors of the text elements and the bounds of the avatars on top of the image")
+	format := flag.String("format", "", "Output format: "+strings.Join(imageio.Formats(), ", ")+" (default: from --output extension, else jpeg)")
+	transparent := flag.Bool("transparent", false, "Render on a transparent canvas instead of the background image (png or gif output)")
+	quality := flag.Int("quality", 0, fmt.Sprintf("JPEG quality from 1 to 100 (default: template output.quality, else %d)", imageio.DefaultJPEGQuality))
+	subsampling := flag.String("subsampling", "", "JPEG chroma subsampling: 4:2:0, 4:2:2 or 4:4:4 (default: template output.subsampling, else "+imageio.DefaultSubsampling+")")
+	resample := flag.String("resample", "", "Resampling filter: "+strings.Join(renderer.ResampleFilters(), ", ")+" (default: template resample.filter, else "+renderer.DefaultResampleFilter+")")
+	linear := flag.Bool("linear", false, "Resample images in linear light instead of sRGB, keeping thin light text from darkening")
+	sharpen := flag.Float64("sharpen", 0, "Amount of unsharp masking applied to images after downscaling, e.g. 0.5 (default: template resample.sharpen, else none)")
+	maxBytes := flag.Int("max-bytes", 0, "Lower the JPEG quality until each image is at most this many bytes, and report SSIM/PSNR")
+	manifestPath := flag.String("manifest", "", "Path of the JSON build manifest (default: artifacts/"+manifest.FileName+" when generating all events, none for a single event)")
+	force := flag.Bool("force", false, "Regenerate all images, even those whose inputs are unchanged since the last run")
+	metadata := flag.Bool("metadata", true, "Embed EXIF/XMP metadata with generated alt text and write a .alt.txt file next to each image")
+	var jobs int
+	flag.IntVar(&jobs, "jobs", 1, "Number of events to render in parallel when generating all events")
+	flag.IntVar(&jobs, "j", 1, "Shorthand for --jobs")
 
 	flag.Parse()
 
+	outputFormat, err := resolveOutputFormat(*format, *outputPath)
+	if err != nil {
+		log.Fatalf("Error selecting output format: %v", err)
+	}
+	if *transparent && !imageio.SupportsTransparency(outputFormat) {
+		log.Fatalf("Error: --transparent needs an output format with an alpha channel, not %s", outputFormat)
+	}
+
+	opts := renderOptions{
+		SmartCrop:   *smartCrop,
+		DebugCrop:   *debugCrop,
+		DebugLayout: *debugLayout,
+		Report:      &imageio.Report{},
+		Format:      outputFormat,
+		Transparent: *transparent,
+		Quality:     *quality,
+		MaxBytes:    *maxBytes,
+		Metadata:    *metadata,
+		Manifest:    &manifest.Manifest{},
+		Force:       *force,
+		Images:      &imageio.Cache{},
+		Resample:    types.ResampleConfig{Linear: *linear, Sharpen: *sharpen},
+	}
+	if opts.Lint, err = parseLintMode(*lint); err != nil {
+		log.Fatalf("Error selecting layout check: %v", err)
+	}
+	if *resample != "" {
+		opts.Resample.Filter, err = renderer.ParseResampleFilter(*resample)
+		if err != nil {
+			log.Fatalf("Error selecting resampling filter: %v", err)
+		}
+	}
+	if *subsampling != "" {
+		opts.Subsampling, err = imageio.ParseSubsampling(*subsampling)
+		if err != nil {
+			log.Fatalf("Error selecting chroma subsampling: %v", err)
+		}
+	}
+	var template *types.Template
+	var outputConfig types.OutputConfig
+	var resampleConfig types.ResampleConfig
+	if *templatePath != "" {
+		template, err = loadTemplate(*templatePath)
+		if err != nil {
+			log.Fatalf("Error loading template: %v", err)
+		}
+		outputConfig = template.Output
+		resampleConfig = template.Resample
+		opts.MetadataConfig = template.Metadata
+	}
+	if err := opts.applyTemplateOutput(outputConfig); err != nil {
+		log.Fatalf("Error in output settings: %v", err)
+	}
+	if err := opts.applyTemplateResample(resampleConfig); err != nil {
+		log.Fatalf("Error in resample settings: %v", err)
+	}
+	sizes, err := outputSizes(*width, *size, *preset, template)
+	if err != nil {
+		log.Fatalf("Error in output sizes: %v", err)
+	}
+
 	// Check templates directory
 	checkTemplatesDirectory()
 
@@ -545,36 +1534,38 @@ func main() {
 
 		fmt.Printf("Generating images for %d events...\n", len(allEventData))
 
-		successCount := 0
-		for _, eventData := range allEventData {
-			err := generateImageForEvent(&eventData, *templatePath, *backgroundPath, *overlayPaths, artifactsDir, *width)
-			if err != nil {
-				log.Printf("Error generating image for event %s: %v", eventData.Title, err)
-			} else {
-				successCount++
-			}
+		if *manifestPath == "" {
+			*manifestPath = filepath.Join(artifactsDir, manifest.FileName)
+		}
+		if err := opts.Manifest.Load(*manifestPath); err != nil {
+			log.Printf("Warning: Ignoring previous manifest: %v", err)
 		}
 
-		fmt.Printf("Successfully generated %d out of %d images.\n", successCount, len(allEventData))
+		successCount, skippedCount := generateAllImages(allEventData, *templatePath, *backgroundPath, *overlayPaths, artifactsDir, sizes, opts, jobs)
+
+		fmt.Printf("Successfully generated %d out of %d images.\n", successCount, len(allEventData)*len(sizes)-skippedCount)
+		if skippedCount > 0 {
+			fmt.Printf("Skipped %d unchanged images (use --force to regenerate them).\n", skippedCount)
+		}
+		printImageReport(opts.Report)
+		writeManifest(opts.Manifest, *manifestPath)
 		return
 	}
 
 	// Generate image for single event (original logic)
-	// Setup output path and artifacts directory
-	finalOutputPath, err := setupOutputPath(*outputPath, *eventID, *width)
+	// Setup output paths and artifacts directory
+	targets, err := setupOutputPaths(*outputPath, *eventID, sizes, imageio.OutputExtension(opts.Format))
 	if err != nil {
 		log.Fatalf("Error setting up output path: %v", err)
 	}
 
-	// Load background image
-	background, err := loadBackgroundImage(*templatePath, *backgroundPath)
-	if err != nil {
-		log.Fatalf("Error loading background image: %v", err)
-	}
-	// Process background and overlay images
-	rgbaFinalImage, err := processImages(background, *overlayPaths)
-	if err != nil {
-		log.Fatalf("Error processing images: %v", err)
+	// Without a manifest there is no previous fingerprint to compare with
+	if *manifestPath != "" {
+		if err := opts.Manifest.Load(*manifestPath); err != nil {
+			log.Printf("Warning: Ignoring previous manifest: %v", err)
+		}
+	} else {
+		opts.Manifest = nil
 	}
 
 	// Load event data if eventID is provided
@@ -586,57 +1577,35 @@ func main() {
 		}
 	}
 
-	// Load template if provided
-	var template *types.Template
-	if *templatePath != "" {
-		tmpl, err := loadTemplate(*templatePath)
-		if err != nil {
-			log.Fatalf("Error loading template: %v", err)
-		}
-		template = tmpl
-	}
-
-	// Render text using template if provided
-	if err := renderTextFromTemplate(*templatePath, eventData, rgbaFinalImage, template); err != nil {
-		log.Fatalf("Error rendering text: %v", err)
-	}
-
-	// Add speaker images if available
-	if eventData != nil && template != nil {
-		if err := addSpeakerImages(rgbaFinalImage, eventData, template); err != nil {
-			log.Printf("Warning: Error adding speaker images: %v", err)
-		}
+	record := newRenderRecord()
+	statuses, err := generateTargets(eventData, *templatePath, *backgroundPath, *overlayPaths, targets, opts, record)
+	if err != nil {
+		log.Fatalf("Error generating image: %v", err)
 	}
-
-	// Resize to width if requested (keep aspect ratio)
-	if *width > 0 {
-		ir := renderer.ImageRenderer{}
-		resized := ir.ResizeKeepAspect(rgbaFinalImage, *width)
-		// Convert to RGBA if needed for further processing
-		if r, ok := resized.(*image.RGBA); ok {
-			rgbaFinalImage = r
+	for i, target := range targets {
+		if statuses[i] == targetSkipped {
+			fmt.Println("Skipping image, it is up to date:", target.Path)
 		} else {
-			tmp := image.NewRGBA(resized.Bounds())
-			draw.Draw(tmp, tmp.Bounds(), resized, image.Point{}, draw.Src)
-			rgbaFinalImage = tmp
+			fmt.Println("Image generated successfully:", target.Path)
 		}
 	}
-
-	// Save final image
-	// If no explicit output file name was provided, adjust default to include width when set
-	if *outputPath == "" && *width > 0 {
-		finalOutputPath = fmt.Sprintf("artifacts/%s-%d.jpg", *eventID, *width)
+	printImageReport(opts.Report)
+	if *manifestPath != "" {
+		writeManifest(opts.Manifest, *manifestPath)
 	}
+}
 
-	err = utils.SaveImage(finalOutputPath, rgbaFinalImage)
-	if err != nil {
-		log.Fatalf("Error saving final image: %v", err)
+// writeManifest saves the build manifest, logging instead of failing since the images were generated
+func writeManifest(m *manifest.Manifest, path string) {
+	if err := m.Write(path); err != nil {
+		log.Printf("Error writing manifest: %v", err)
+		return
 	}
-
-	fmt.Println("Image generated successfully:", finalOutputPath)
+	fmt.Println("Manifest written:", path)
 }
 
-func wrapText(text string, maxWidth int, font *opentype.Font, fontSize float64) []string {
+// wrapParagraph greedily wraps a single paragraph at word boundaries
+func wrapParagraph(text string, maxWidth int, font *opentype.Font, fontSize float64, logger *log.Logger) ([]string, error) {
 	wrapped := []string{}
 	words := strings.Fields(text)
 	line := ""
@@ -646,10 +1615,13 @@ func wrapText(text string, maxWidth int, font *opentype.Font, fontSize float64)
 			line = word
 		} else {
 			testLine := line + " " + word
-			width := measureTextWidth(testLine, font, fontSize)
-			log.Printf("[wrapText] testLine: '%s', width: %.2f, maxWidth: %d", testLine, width, maxWidth)
+			width, err := measureTextWidth(testLine, font, fontSize, logger)
+			if err != nil {
+				return nil, err
+			}
+			logger.Printf("[wrapText] testLine: '%s', width: %.2f, maxWidth: %d", testLine, width, maxWidth)
 			if width > float64(maxWidth) {
-				log.Printf("[wrapText] Wrapping line: '%s' (width %.2f > maxWidth %d)", line, measureTextWidth(line, font, fontSize), maxWidth)
+				logger.Printf("[wrapText] Wrapping line: '%s' (maxWidth %d)", line, maxWidth)
 				wrapped = append(wrapped, line)
 				line = word
 			} else {
@@ -659,28 +1631,15 @@ func wrapText(text string, maxWidth int, font *opentype.Font, fontSize float64)
 	}
 
 	if line != "" {
-		log.Printf("[wrapText] Final line: '%s' (width %.2f)", line, measureTextWidth(line, font, fontSize))
+		logger.Printf("[wrapText] Final line: '%s'", line)
 		wrapped = append(wrapped, line)
 	}
 
-	return wrapped
-}
-
-// Define a utility function to load fonts
-func loadFont(fontPath string) *opentype.Font {
-	fontBytes, err := os.ReadFile(fontPath)
-	if err != nil {
-		log.Fatalf("Error reading font file: %v", err)
-	}
-	font, err := opentype.Parse(fontBytes)
-	if err != nil {
-		log.Fatalf("Error parsing font: %v", err)
-	}
-	return font
+	return wrapped, nil
 }
 
 // Define a utility function to measure text width
-func measureTextWidth(text string, fontFile *opentype.Font, fontSize float64) float64 {
+func measureTextWidth(text string, fontFile *opentype.Font, fontSize float64, logger *log.Logger) (float64, error) {
 	// Set DPI and Hinting for better compatibility
 	face, err := opentype.NewFace(fontFile, &opentype.FaceOptions{
 		Size:    fontSize,
@@ -688,7 +1647,7 @@ func measureTextWidth(text string, fontFile *opentype.Font, fontSize float64) fl
 		Hinting: font.HintingFull,
 	})
 	if err != nil {
-		log.Fatalf("Error creating font face: %v", err)
+		return 0, fmt.Errorf("error creating font face: %w", err)
 	}
 	defer face.Close()
 	var d font.Drawer
@@ -696,12 +1655,12 @@ func measureTextWidth(text string, fontFile *opentype.Font, fontSize float64) fl
 	width := d.MeasureString(text)
 	w := float64(width) / 64.0
 	if w == 0.0 && len(text) > 0 {
-		log.Printf("[measureTextWidth] WARNING: Measured width is 0.0 for text '%s' with custom font. Font file may be invalid or incompatible.", text)
+		logger.Printf("[measureTextWidth] WARNING: Measured width is 0.0 for text '%s' with custom font. Font file may be invalid or incompatible.", text)
 		// Fallback to basicfont.Face7x13
 		var fallback font.Drawer
 		fallback.Face = basicfont.Face7x13
 		w = float64(fallback.MeasureString(text)) / 64.0
-		log.Printf("[measureTextWidth] Fallback width: %.2f", w)
+		logger.Printf("[measureTextWidth] Fallback width: %.2f", w)
 	}
-	return w
+	return w, nil
 }
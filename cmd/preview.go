@@ -0,0 +1,398 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go-image-generator/pkg/imageio"
+	"go-image-generator/pkg/manifest"
+	"go-image-generator/pkg/renderer"
+	"go-image-generator/pkg/templates"
+	"go-image-generator/pkg/types"
+)
+
+// preview runs the preview subcommand: a web page showing the image of an event with the
+// current template, reloaded in the browser whenever a file it was rendered from changes
+func preview(args []string) {
+	flags := flag.NewFlagSet("preview", flag.ExitOnError)
+	addr := flags.String("addr", "localhost:8090", "Address to listen on")
+	templatePath := flags.String("template", "assets/templates/template.json", "Path to the JSON template file")
+	backgroundPath := flags.String("background", "", "Path to the background image (used only if the template has none)")
+	overlayPaths := flags.String("overlays", "", "Comma-separated paths to overlay images")
+	eventsFile := flags.String("file", "", "Path to local events.yml file (instead of remote URL); a local file is watched for changes")
+	eventID := flags.String("id", "", "ID of the event shown by default (default: the last event)")
+	smartCrop := flags.Bool("smart-crop", false, "Crop speaker images based on their content instead of centering them")
+	interval := flags.Duration("interval", 500*time.Millisecond, "How often the watched files are checked for changes")
+	flags.Parse(args)
+
+	p := &previewServer{
+		templatePath:   *templatePath,
+		backgroundPath: *backgroundPath,
+		overlayPaths:   *overlayPaths,
+		eventsFile:     *eventsFile,
+		eventID:        *eventID,
+		smartCrop:      *smartCrop,
+		clients:        make(map[chan int]struct{}),
+	}
+	p.reset()
+	ids, err := p.events.list()
+	if err != nil {
+		log.Fatalf("Error loading events: %v", err)
+	}
+	if len(ids) == 0 {
+		log.Fatal("Error: no events to preview")
+	}
+	go p.watch(*interval)
+
+	fmt.Printf("Previewing %s on http://%s/\n", *templatePath, *addr)
+	log.Fatal(http.ListenAndServe(*addr, p.handler()))
+}
+
+// previewServer renders event images for the preview page. It watches the files the
+// last image was rendered from and tells the open pages to reload when one changes.
+type previewServer struct {
+	templatePath   string
+	backgroundPath string
+	overlayPaths   string
+	eventsFile     string
+	eventID        string
+	smartCrop      bool
+
+	mu      sync.Mutex
+	version int
+	events  *eventSource
+	images  *imageio.Cache
+	// watched holds the modification time and size of each watched file, or the zero
+	// stamp for files that did not exist
+	watched map[string]fileStamp
+	clients map[chan int]struct{}
+}
+
+// fileStamp identifies a version of a file
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// stat returns the stamp of the file at path, or the zero stamp if it does not exist
+func stat(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}
+}
+
+// reset drops the cached templates, fonts, images and events and watches only the
+// template and events file until the next render. The caller must hold p.mu, unless
+// the server is not running yet.
+func (p *previewServer) reset() {
+	forgetTemplates()
+	renderer.ForgetFonts()
+	p.images = &imageio.Cache{}
+	p.events = &eventSource{file: p.eventsFile}
+	p.watched = map[string]fileStamp{}
+	p.addWatched(p.templatePath)
+	if p.eventsFile != "" {
+		p.addWatched(p.eventsFile)
+	}
+}
+
+// addWatched adds files to the watched files. The caller must hold p.mu.
+func (p *previewServer) addWatched(paths ...string) {
+	for _, path := range paths {
+		if _, ok := p.watched[path]; !ok {
+			p.watched[path] = stat(path)
+		}
+	}
+}
+
+// watch checks the watched files every interval and reloads the pages once one changed
+func (p *previewServer) watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		p.mu.Lock()
+		var changed []string
+		for path, stamp := range p.watched {
+			if stat(path) != stamp {
+				changed = append(changed, path)
+			}
+		}
+		if len(changed) > 0 {
+			log.Printf("Changed: %s", strings.Join(changed, ", "))
+			p.reset()
+			p.version++
+			for client := range p.clients {
+				select {
+				case client <- p.version:
+				default:
+				}
+			}
+		}
+		p.mu.Unlock()
+	}
+}
+
+// handler returns the routes of the preview:
+//
+//	GET /                        the preview page; id, preset and debug select the image
+//	GET /image?id=44&preset=...  the image shown by the page, with the layout drawn on
+//	                             top of it if debug is set
+//	GET /changes                 a stream of events, one per change of a watched file
+func (p *previewServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", p.handlePage)
+	mux.HandleFunc("GET /image", p.handleImage)
+	mux.HandleFunc("GET /changes", p.handleChanges)
+	return mux
+}
+
+// previewPage is the page showing the image. It loads the image again for every change
+// event, and shows the error instead if the image cannot be rendered.
+var previewPage = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Preview {{.Template}}</title>
+<style>
+body { margin: 0; font-family: sans-serif; background: #222; color: #eee; }
+form { padding: 8px 12px; }
+img { display: block; max-width: 100%; margin: 0 auto; }
+pre { margin: 12px; color: #f88; white-space: pre-wrap; }
+ul { margin: 0 12px 12px; padding-left: 20px; color: #fd6; }
+</style>
+</head>
+<body>
+<form>
+<label>Event <select name="id" onchange="this.form.submit()">
+{{- range .IDs}}<option{{if eq . $.ID}} selected{{end}}>{{.}}</option>{{end -}}
+</select></label>
+<label>Size <select name="preset" onchange="this.form.submit()">
+<option value="">full size</option>
+{{- range .Presets}}<option{{if eq . $.Preset}} selected{{end}}>{{.}}</option>{{end -}}
+</select></label>
+<label><input type="checkbox" name="debug" value="1"{{if .Debug}} checked{{end}} onchange="this.form.submit()"> Layout</label>
+<span id="status"></span>
+</form>
+<pre id="error"></pre>
+<ul id="warnings"></ul>
+<img id="image" alt="">
+<script>
+const source = {{.ImageURL}};
+const image = document.getElementById("image");
+const error = document.getElementById("error");
+const status = document.getElementById("status");
+const warnings = document.getElementById("warnings");
+async function load() {
+	status.textContent = "rendering…";
+	try {
+		const response = await fetch(source, {cache: "no-store"});
+		if (!response.ok) {
+			error.textContent = await response.text();
+			return;
+		}
+		error.textContent = "";
+		const header = response.headers.get("X-Render-Warning");
+		warnings.replaceChildren(...(header ? header.split(", ") : []).map(warning => {
+			const item = document.createElement("li");
+			item.textContent = decodeURIComponent(warning.replace(/\+/g, " "));
+			return item;
+		}));
+		const previous = image.src;
+		image.src = URL.createObjectURL(await response.blob());
+		if (previous) URL.revokeObjectURL(previous);
+	} catch (e) {
+		error.textContent = String(e);
+	} finally {
+		status.textContent = "rendered at " + new Date().toLocaleTimeString();
+	}
+}
+// The stream sends the current version when it connects, including reconnects after a restart
+new EventSource("/changes").onmessage = load;
+</script>
+</body>
+</html>
+`))
+
+// handlePage serves the preview page
+func (p *previewServer) handlePage(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	events := p.events
+	p.mu.Unlock()
+	ids, err := events.list()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	id := p.selectedID(r, ids)
+	preset := r.URL.Query().Get("preset")
+	debug := r.URL.Query().Get("debug") != ""
+	query := url.Values{"id": {id}}
+	if preset != "" {
+		query.Set("preset", preset)
+	}
+	if debug {
+		query.Set("debug", "1")
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	err = previewPage.Execute(w, struct {
+		Template string
+		IDs      []string
+		ID       string
+		Presets  []string
+		Preset   string
+		Debug    bool
+		ImageURL string
+	}{p.templatePath, ids, id, templates.PresetNames(), preset, debug, "/image?" + query.Encode()})
+	if err != nil {
+		log.Printf("Error writing preview page: %v", err)
+	}
+}
+
+// selectedID returns the event selected by the id query parameter, else by --id, else
+// the last event
+func (p *previewServer) selectedID(r *http.Request, ids []string) string {
+	if id := r.URL.Query().Get("id"); id != "" {
+		return id
+	}
+	if p.eventID != "" {
+		return p.eventID
+	}
+	if len(ids) == 0 {
+		return ""
+	}
+	return ids[len(ids)-1]
+}
+
+// handleImage renders the image of an event. Errors are sent as plain text, which the
+// page shows in place of the image.
+func (p *previewServer) handleImage(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	query := r.URL.Query()
+	size, err := requestSize(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p.mu.Lock()
+	events, images, version := p.events, p.images, p.version
+	p.mu.Unlock()
+	eventData, err := events.event(query.Get("id"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, errEventNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	opts := renderOptions{
+		SmartCrop:   p.smartCrop,
+		DebugLayout: query.Get("debug") != "",
+		Format:      "jpeg",
+		Images:      images,
+		Manifest:    &manifest.Manifest{},
+	}
+	record := newBufferedRecord()
+	defer record.flush()
+	templatePath := variantTemplatePath(p.templatePath, size, record.renderRecord)
+	data, files, err := p.render(eventData, templatePath, size, opts, record.renderRecord)
+
+	// Watch the files of this render, unless they changed again while it was rendered
+	p.mu.Lock()
+	if p.version == version {
+		p.addWatched(templatePath)
+		p.addWatched(files...)
+	}
+	p.mu.Unlock()
+
+	if errors.Is(err, errInvalidSize) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error rendering event %s: %v", eventData.Title, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Warnings, such as layout problems, are listed by the page. They are escaped to
+	// keep non-ASCII text and commas intact in the header.
+	for _, warning := range record.Warnings {
+		w.Header().Add("X-Render-Warning", url.QueryEscape(warning))
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(data)
+	log.Printf("Rendered event %s in %v", eventData.Title, time.Since(start).Round(time.Millisecond))
+}
+
+// render renders and encodes the image of an event, returning it with the files it
+// was rendered from
+func (p *previewServer) render(eventData *types.EventData, templatePath string, size outputSize, opts renderOptions, record *renderRecord) ([]byte, []string, error) {
+	plan, err := planEventImage(eventData, templatePath, p.backgroundPath, p.overlayPaths, record)
+	if err != nil {
+		return nil, nil, err
+	}
+	files := plan.inputFiles()
+	if err := plan.checkOutputSize(size.Spec); err != nil {
+		return nil, files, err
+	}
+	if plan.Template != nil {
+		if err := opts.applyTemplateOutput(plan.Template.Output); err != nil {
+			return nil, files, err
+		}
+		if err := opts.applyTemplateResample(plan.Template.Resample); err != nil {
+			return nil, files, err
+		}
+	}
+	composition, err := plan.render(opts, record)
+	if err != nil {
+		return nil, files, err
+	}
+	img := plan.resize(composition, size.Spec, opts)
+	data, err := encodeOutputImage(eventData.Title, img, eventData, opts, record)
+	return data, files, err
+}
+
+// handleChanges streams a server-sent event with the current version when the page
+// connects, and another one whenever a watched file changes
+func (p *previewServer) handleChanges(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	client := make(chan int, 1)
+	p.mu.Lock()
+	p.clients[client] = struct{}{}
+	client <- p.version
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.clients, client)
+		p.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	for {
+		select {
+		case version := <-client:
+			fmt.Fprintf(w, "data: %d\n\n", version)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go-image-generator/pkg/imageio"
+	"go-image-generator/pkg/manifest"
+	"go-image-generator/pkg/renderer"
+	"go-image-generator/pkg/templates"
+	"go-image-generator/pkg/types"
+)
+
+// errInvalidRender is returned for render requests that cannot be rendered as given
+var errInvalidRender = errors.New("invalid render request")
+
+// renderRequest is a template and the data document filling its placeholders, rendered
+// by the render subcommand and the POST /render endpoint. Size is a resize spec or preset
+// name (default: the full size) and Format an output format (default: jpeg).
+type renderRequest struct {
+	Template *types.Template `json:"template"`
+	Data     map[string]any  `json:"data"`
+	Size     string          `json:"size"`
+	Format   string          `json:"format"`
+}
+
+// prepare validates the request and plans its image. The output and resample settings of
+// the template fill those not set in opts, and the template is named name in logs and
+// fingerprints. With an assets directory, the template may only use files in it.
+func (req renderRequest) prepare(name, assetsDir string, opts renderOptions, record *renderRecord) (*renderPlan, outputSize, renderOptions, error) {
+	invalid := func(err error) (*renderPlan, outputSize, renderOptions, error) {
+		return nil, outputSize{}, opts, fmt.Errorf("%w: %v", errInvalidRender, err)
+	}
+	if req.Template == nil {
+		return invalid(errors.New("no template given"))
+	}
+
+	format := "jpeg"
+	if req.Format != "" {
+		var err error
+		if format, err = imageio.ParseFormat(req.Format); err != nil {
+			return invalid(err)
+		}
+	}
+	opts.Format = format
+	if err := opts.applyTemplateOutput(req.Template.Output); err != nil {
+		return invalid(err)
+	}
+	if err := opts.applyTemplateResample(req.Template.Resample); err != nil {
+		return invalid(err)
+	}
+
+	size := outputSize{Spec: renderer.ResizeSpec{Density: 1}}
+	if req.Size != "" {
+		sizes, err := parseSizes(req.Size)
+		if err != nil {
+			return invalid(err)
+		}
+		if len(sizes) != 1 {
+			return invalid(fmt.Errorf("expected one size, got %q", req.Size))
+		}
+		size = sizes[0]
+		if err := checkResizeSpec(size.Spec); err != nil {
+			return invalid(err)
+		}
+	}
+
+	template := *req.Template
+	if err := expandTemplate(&template, req.Data); err != nil {
+		return invalid(err)
+	}
+	if template.Background.Image == "" {
+		return invalid(errors.New("the template has no background image"))
+	}
+	if err := checkTemplateLimits(&template); err != nil {
+		return invalid(err)
+	}
+	if assetsDir != "" {
+		if err := checkAssetPaths(&template, assetsDir); err != nil {
+			return invalid(err)
+		}
+	}
+	width, height, err := compositionSize(template.Background, template.Background.Image)
+	if err != nil {
+		return invalid(err)
+	}
+	if err := checkDimensions(width, height); err != nil {
+		return invalid(err)
+	}
+	if err := checkDimensions(size.Spec.Dimensions(width, height)); err != nil {
+		return invalid(err)
+	}
+
+	plan := &renderPlan{
+		TemplatePath:   name,
+		Template:       &template,
+		BackgroundPath: template.Background.Image,
+	}
+	plan.SpeakerImages, plan.ImageElements = placedImages(&template, record)
+	return plan, size, opts, nil
+}
+
+// renderDocument renders a planned render request and encodes it
+func renderDocument(plan *renderPlan, size outputSize, opts renderOptions, record *renderRecord) ([]byte, error) {
+	composition, err := plan.render(opts, record)
+	if err != nil {
+		return nil, err
+	}
+	img := plan.resize(composition, size.Spec, opts)
+	return encodeOutputImage(plan.TemplatePath, img, nil, opts, record)
+}
+
+// expandTemplate fills the placeholders in the title, date, sponsor, texts and image
+// sources of template from data. The texts and images are copied, so that a template
+// shared with other renders is left unchanged.
+func expandTemplate(template *types.Template, data map[string]any) error {
+	template.Texts = append([]types.TextElement(nil), template.Texts...)
+	template.Images = append([]types.PlacedImage(nil), template.Images...)
+
+	elements := []*types.TextElement{&template.Title, &template.Date, &template.Sponsor}
+	for i := range template.Texts {
+		elements = append(elements, &template.Texts[i])
+	}
+	for _, element := range elements {
+		text, err := templates.Expand(element.Text, data)
+		if err != nil {
+			return err
+		}
+		element.Text = text
+	}
+	for i := range template.Images {
+		source, err := templates.Expand(template.Images[i].Source, data)
+		if err != nil {
+			return err
+		}
+		template.Images[i].Source = source
+	}
+	return nil
+}
+
+// checkAssetPaths reports an error if a file drawn by template is not inside dir, so that
+// render requests cannot read other files of the server
+func checkAssetPaths(template *types.Template, dir string) error {
+	paths := []string{template.Background.Image, template.Title.Font, template.Date.Font, template.Sponsor.Font}
+	for _, element := range template.Texts {
+		paths = append(paths, element.Font)
+	}
+	for _, placed := range template.Images {
+		paths = append(paths, placed.Source)
+	}
+
+	dir = filepath.Clean(dir)
+	// Symbolic links are followed, so that links in dir cannot point to other files
+	realDir, err := realPath(dir)
+	if err != nil {
+		return fmt.Errorf("error resolving %s: %w", dir, err)
+	}
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		clean := filepath.Clean(path)
+		if !filepath.IsLocal(clean) || (dir != "." && !strings.HasPrefix(clean, dir+string(filepath.Separator))) {
+			return fmt.Errorf("%q is not a file in %s", path, dir)
+		}
+		real, err := realPath(clean)
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%q does not exist", path)
+		} else if err != nil {
+			return fmt.Errorf("error resolving %q: %w", path, err)
+		}
+		if !strings.HasPrefix(real, realDir+string(filepath.Separator)) {
+			return fmt.Errorf("%q is not a file in %s", path, dir)
+		}
+	}
+	return nil
+}
+
+// realPath returns the absolute path of path with all symbolic links resolved
+func realPath(path string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(resolved)
+}
+
+// checkTemplateLimits reports an error if an element of template is drawn larger than
+// maxImageDimension or filtered with a radius over maxFilterRadius, as the memory and
+// time taken to render them grow with these sizes
+func checkTemplateLimits(template *types.Template) error {
+	inRange := func(name string, value float64, limit int) error {
+		if !(value >= 0 && value <= float64(limit)) {
+			return fmt.Errorf("%s %g is not between 0 and %d", name, value, limit)
+		}
+		return nil
+	}
+
+	background := template.Background.Size
+	checks := []error{
+		inRange("background width", float64(background.Width), maxImageDimension),
+		inRange("background height", float64(background.Height), maxImageDimension),
+		inRange("title font size", template.Title.FontSize, maxImageDimension),
+		inRange("date font size", template.Date.FontSize, maxImageDimension),
+		inRange("sponsor font size", template.Sponsor.FontSize, maxImageDimension),
+	}
+	for i, element := range template.Texts {
+		checks = append(checks, inRange(fmt.Sprintf("texts[%d] font size", i), element.FontSize, maxImageDimension))
+	}
+	for i, placed := range template.Images {
+		name := fmt.Sprintf("images[%d]", i)
+		checks = append(checks,
+			inRange(name+" size", float64(placed.Size), maxImageDimension),
+			inRange(name+" gap", float64(placed.Gap), maxImageDimension),
+			inRange(name+" border width", float64(placed.BorderWidth), maxImageDimension))
+		for _, filter := range placed.Filters {
+			checks = append(checks, inRange(name+" "+filter.Type+" radius", filter.Radius, maxFilterRadius))
+		}
+	}
+	if panel := template.Contrast.Panel; panel != nil {
+		checks = append(checks,
+			inRange("contrast panel padding", float64(panel.Padding), maxImageDimension),
+			inRange("contrast panel radius", float64(panel.Radius), maxImageDimension))
+	}
+
+	for _, err := range checks {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compositionSize returns the size of the full-size image of a template with the given
+// background settings: its canvas, else the size it draws the background at, else the
+// size of the background image at path
+func compositionSize(background types.BackgroundConfig, path string) (int, int, error) {
+	if background.Canvas.Width > 0 && background.Canvas.Height > 0 {
+		return background.Canvas.Width, background.Canvas.Height, nil
+	}
+	if background.Size.Width > 0 && background.Size.Height > 0 {
+		return background.Size.Width, background.Size.Height, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error opening background image: %w", err)
+	}
+	defer file.Close()
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error reading background image: %w", err)
+	}
+	return config.Width, config.Height, nil
+}
+
+// checkDimensions reports an error if an image of width x height exceeds maxImageDimension
+func checkDimensions(width, height int) error {
+	if width > maxImageDimension || height > maxImageDimension {
+		return fmt.Errorf("%dx%d exceeds the limit of %d pixels per side", width, height, maxImageDimension)
+	}
+	return nil
+}
+
+// checkOutputSize reports an error wrapping errInvalidSize if the composition of the plan,
+// or its output at spec, exceeds maxImageDimension, before anything is rendered
+func (p *renderPlan) checkOutputSize(spec renderer.ResizeSpec) error {
+	var background types.BackgroundConfig
+	if p.Template != nil {
+		background = p.Template.Background
+	}
+	width, height, err := compositionSize(background, p.BackgroundPath)
+	if err != nil {
+		return err
+	}
+	if err := checkDimensions(width, height); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidSize, err)
+	}
+	if err := checkDimensions(spec.Dimensions(width, height)); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidSize, err)
+	}
+	return nil
+}
+
+// renderCommand runs the render subcommand: it renders a template filled from a data
+// document, like the POST /render endpoint of the server
+func renderCommand(args []string) {
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	templatePath := flags.String("template", "", "Path to the JSON template file")
+	dataPath := flags.String("data", "", "Path to the JSON data document filling the placeholders of the template")
+	outputPath := flags.String("output", "", "Path to save the image (default: artifacts/render.jpg)")
+	size := flags.String("size", "", "Output size: a resize spec such as 550 or fill=1080x1080, or a preset name (default: full size)")
+	format := flags.String("format", "", "Output format: "+strings.Join(imageio.Formats(), ", ")+" (default: from --output extension, else jpeg)")
+	timeout := flags.Duration("timeout", time.Minute, "Maximum time to render the image")
+	flags.Parse(args)
+
+	if *templatePath == "" {
+		log.Fatal("Error: --template is required")
+	}
+	template, err := loadTemplate(*templatePath)
+	if err != nil {
+		log.Fatalf("Error loading template: %v", err)
+	}
+	req := renderRequest{Template: template, Size: *size}
+	if *dataPath != "" {
+		data, err := os.ReadFile(*dataPath)
+		if err != nil {
+			log.Fatalf("Error reading data: %v", err)
+		}
+		if err := json.Unmarshal(data, &req.Data); err != nil {
+			log.Fatalf("Error parsing data JSON: %v", err)
+		}
+	}
+	if req.Format, err = resolveOutputFormat(*format, *outputPath); err != nil {
+		log.Fatalf("Error selecting output format: %v", err)
+	}
+	if *outputPath == "" {
+		*outputPath = filepath.Join("artifacts", "render"+imageio.OutputExtension(req.Format))
+	}
+
+	record := newRenderRecord()
+	opts := renderOptions{Metadata: true, Images: &imageio.Cache{}}
+	plan, target, opts, err := req.prepare(*templatePath, "", opts, record)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	// Stop rendering once the timeout passes, like render requests of the server
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	opts.Context = ctx
+	data, err := renderDocument(plan, target, opts, record)
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Fatalf("Error: rendering took longer than %v", *timeout)
+	} else if err != nil {
+		log.Fatalf("Error rendering image: %v", err)
+	}
+	if err := os.WriteFile(*outputPath, data, 0644); err != nil {
+		log.Fatalf("Error saving image: %v", err)
+	}
+	fmt.Println("Image generated successfully:", *outputPath)
+}
+
+// handleRender renders the template and data document posted as a render request
+func (s *server) handleRender(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	var req renderRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, s.maxRequestBytes)).Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("render requests are limited to %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, fmt.Sprintf("%v: %v", errInvalidRender, err), http.StatusBadRequest)
+		return
+	}
+
+	// Wait for a free render slot, unless the request times out first
+	release, err := s.acquireRender(r.Context())
+	if err != nil {
+		return
+	}
+	defer release()
+
+	opts := s.renderOpts
+	opts.Manifest = &manifest.Manifest{}
+	// Stop rendering once the request times out, so that its render slot is released
+	opts.Context = r.Context()
+	record := newBufferedRecord()
+	defer record.flush()
+	plan, size, opts, err := req.prepare("render request", s.assetsDir, opts, record.renderRecord)
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	s.forgetChanged(opts.Manifest, plan.inputFiles()...)
+	fingerprint, err := plan.fingerprint(opts, size.Spec)
+	if err != nil {
+		s.error(w, r, fmt.Errorf("error fingerprinting inputs: %w", err))
+		return
+	}
+	extension := imageio.OutputExtension(opts.Format)
+	data, _, cached, err := s.cache.get(r.Context(), fingerprint, extension, func() ([]byte, []string, error) {
+		data, err := renderDocument(plan, size, opts, record.renderRecord)
+		return data, record.Warnings, err
+	})
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", `"`+fingerprint+`"`)
+	http.ServeContent(w, r, "render"+extension, time.Time{}, bytes.NewReader(data))
+
+	source := "rendered"
+	if cached {
+		source = "cached"
+	}
+	log.Printf("%s %s: %s in %v", r.Method, r.URL.RequestURI(), source, time.Since(start).Round(time.Millisecond))
+}
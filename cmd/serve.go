@@ -0,0 +1,671 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-image-generator/pkg/imageio"
+	"go-image-generator/pkg/manifest"
+	"go-image-generator/pkg/renderer"
+	"go-image-generator/pkg/templates"
+	"go-image-generator/pkg/types"
+)
+
+const (
+	// maxImageDimension limits the width and height of images rendered on request
+	maxImageDimension = 4096
+	// maxFilterRadius limits the radius of blur and sharpen filters in posted templates
+	maxFilterRadius = 100
+	// maxCachedImages limits the number of encoded images kept in memory by the server
+	maxCachedImages = 256
+)
+
+// errEventNotFound is returned for event IDs that are not in the events source
+var errEventNotFound = errors.New("event not found")
+
+// errInvalidSize is returned for output sizes that exceed the limits of the server
+var errInvalidSize = errors.New("invalid size")
+
+// serve runs the serve subcommand: an HTTP server that renders event images on request
+func serve(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", ":8080", "Address to listen on")
+	templatePath := flags.String("template", "assets/templates/template.json", "Path to the JSON template file")
+	backgroundPath := flags.String("background", "", "Path to the background image (used only if the template has none)")
+	overlayPaths := flags.String("overlays", "", "Comma-separated paths to overlay images")
+	eventsFile := flags.String("file", "", "Path to local events.yml file (instead of remote URL)")
+	refresh := flags.Duration("refresh", 5*time.Minute, "How long loaded events are used before they are reloaded; 0 loads them once")
+	cacheDir := flags.String("cache-dir", "", "Directory in which rendered images are kept across restarts (default: memory only)")
+	cacheMaxBytes := flags.Int64("cache-max-bytes", 512<<20, "Maximum size of the images in --cache-dir; the least recently used are removed first")
+	maxAge := flags.Duration("max-age", 5*time.Minute, "How long clients and proxies may use an image before revalidating it")
+	smartCrop := flags.Bool("smart-crop", false, "Crop speaker images based on their content instead of centering them")
+	quality := flags.Int("quality", 0, fmt.Sprintf("JPEG quality from 1 to 100 (default: template output.quality, else %d)", imageio.DefaultJPEGQuality))
+	subsampling := flags.String("subsampling", "", "JPEG chroma subsampling: 4:2:0, 4:2:2 or 4:4:4 (default: template output.subsampling, else "+imageio.DefaultSubsampling+")")
+	metadata := flags.Bool("metadata", true, "Embed EXIF/XMP metadata with generated alt text in served images")
+	assetsDir := flags.String("assets", "assets", "Directory that the templates of render requests may use files from")
+	maxRequestBytes := flags.Int64("max-request-bytes", 1<<20, "Maximum size of a render request body")
+	renderTimeout := flags.Duration("render-timeout", 10*time.Second, "Maximum time to answer a render request")
+	maxRenders := flags.Int("max-renders", runtime.NumCPU(), "Maximum number of images rendered at once, for event images and render requests")
+	flags.Parse(args)
+
+	opts := renderOptions{
+		SmartCrop: *smartCrop,
+		Format:    "jpeg",
+		Quality:   *quality,
+		Metadata:  *metadata,
+		Images:    &imageio.Cache{},
+	}
+	var err error
+	if *subsampling != "" {
+		opts.Subsampling, err = imageio.ParseSubsampling(*subsampling)
+		if err != nil {
+			log.Fatalf("Error selecting chroma subsampling: %v", err)
+		}
+	}
+	// Render requests complete the command line settings from their own template
+	renderOpts := opts
+	template, err := loadTemplate(*templatePath)
+	if err != nil {
+		log.Fatalf("Error loading template: %v", err)
+	}
+	opts.MetadataConfig = template.Metadata
+	if err := opts.applyTemplateOutput(template.Output); err != nil {
+		log.Fatalf("Error in output settings: %v", err)
+	}
+	if err := opts.applyTemplateResample(template.Resample); err != nil {
+		log.Fatalf("Error in resample settings: %v", err)
+	}
+	sizes, err := outputSizes("", "", "", template)
+	if err != nil {
+		log.Fatalf("Error in output sizes: %v", err)
+	}
+	if *cacheDir != "" {
+		if err := os.MkdirAll(*cacheDir, 0755); err != nil {
+			log.Fatalf("Error creating cache directory: %v", err)
+		}
+	}
+
+	s := &server{
+		templatePath:    *templatePath,
+		backgroundPath:  *backgroundPath,
+		overlayPaths:    *overlayPaths,
+		opts:            opts,
+		sizes:           sizes,
+		events:          &eventSource{file: *eventsFile, refresh: *refresh},
+		cache:           &renderCache{dir: *cacheDir, maxBytes: *cacheMaxBytes},
+		cacheControl:    fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())),
+		renderOpts:      renderOpts,
+		assetsDir:       *assetsDir,
+		maxRequestBytes: *maxRequestBytes,
+		renderTimeout:   *renderTimeout,
+		renders:         make(chan struct{}, max(*maxRenders, 1)),
+	}
+	count, err := s.events.count()
+	if err != nil {
+		log.Fatalf("Error loading events: %v", err)
+	}
+
+	fmt.Printf("Serving images of %d events on %s\n", count, *addr)
+	log.Fatal(http.ListenAndServe(*addr, s.handler()))
+}
+
+// server renders event images on request. Images are cached by the fingerprint of
+// their inputs, which also serves as their ETag.
+type server struct {
+	templatePath   string
+	backgroundPath string
+	overlayPaths   string
+	opts           renderOptions
+	sizes          []outputSize
+	events         *eventSource
+	cache          *renderCache
+	cacheControl   string
+	// Render requests start from renderOpts, may only use files under assetsDir, and
+	// are limited in body size and duration. Renders holds a slot for every image being
+	// rendered, of events and render requests alike.
+	renderOpts      renderOptions
+	assetsDir       string
+	maxRequestBytes int64
+	renderTimeout   time.Duration
+	renders         chan struct{}
+	// loaded holds the SHA-256 of the templates, fonts and images when they were last
+	// seen, to drop them from their caches once they change
+	loadedMu sync.Mutex
+	loaded   map[string]string
+}
+
+// servedImage is an encoded event image and the inputs it was rendered from
+type servedImage struct {
+	data        []byte
+	fingerprint string
+	inputs      manifest.Inputs
+	warnings    []string
+	cached      bool
+}
+
+// handler returns the routes of the server:
+//
+//	GET /events/{id}.jpg?width=550     the image of an event, also .png and .gif;
+//	                                   width, size or preset select the size
+//	GET /events/{id}/manifest          the outputs of the template for the event
+//	POST /render                       the image of a template and data document
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /events/{file}", s.handleImage)
+	mux.HandleFunc("GET /events/{id}/manifest", s.handleManifest)
+	mux.Handle("POST /render", http.TimeoutHandler(http.HandlerFunc(s.handleRender), s.renderTimeout, "render request timed out\n"))
+	return mux
+}
+
+// handleImage serves the image of an event in the size given by the query
+func (s *server) handleImage(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	file := r.PathValue("file")
+	format := imageio.FormatFromPath(file)
+	if format == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if _, err := imageio.ParseFormat(format); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	size, err := requestSize(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	img, err := s.image(r.Context(), strings.TrimSuffix(file, filepath.Ext(file)), size, format)
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", `"`+img.fingerprint+`"`)
+	w.Header().Set("Cache-Control", s.cacheControl)
+	http.ServeContent(w, r, file, time.Time{}, bytes.NewReader(img.data))
+
+	source := "rendered"
+	if img.cached {
+		source = "cached"
+	}
+	log.Printf("%s %s: %s in %v", r.Method, r.URL.RequestURI(), source, time.Since(start).Round(time.Millisecond))
+}
+
+// handleManifest serves the manifest of the outputs of the template for an event,
+// rendering the images that are not cached yet
+func (s *server) handleManifest(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	outputs := []manifest.Output{}
+	for _, size := range s.sizes {
+		format := s.opts.Format
+		img, err := s.image(r.Context(), id, size, format)
+		if err != nil {
+			s.error(w, r, err)
+			return
+		}
+		output := manifest.Output{
+			EventID:     id,
+			Variant:     size.variant(),
+			Path:        imageURL(id, size, format),
+			Fingerprint: img.fingerprint,
+			Bytes:       int64(len(img.data)),
+			Inputs:      img.inputs,
+			Warnings:    img.warnings,
+		}
+		if config, _, err := image.DecodeConfig(bytes.NewReader(img.data)); err == nil {
+			output.Width, output.Height = config.Width, config.Height
+		}
+		sum := sha256.Sum256(img.data)
+		output.SHA256 = hex.EncodeToString(sum[:])
+		outputs = append(outputs, output)
+	}
+
+	data, err := json.MarshalIndent(struct {
+		Outputs []manifest.Output `json:"outputs"`
+	}{outputs}, "", "  ")
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	sum := sha256.Sum256(data)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", "application/json")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+// error responds with the status matching err
+func (s *server) error(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errEventNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errInvalidRender) || errors.Is(err, errInvalidSize) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.Context().Err() != nil && errors.Is(err, r.Context().Err()) {
+		// The client is gone or the request timed out, nobody reads the answer
+		log.Printf("%s %s: canceled", r.Method, r.URL.RequestURI())
+		return
+	}
+	log.Printf("%s %s: %v", r.Method, r.URL.RequestURI(), err)
+	http.Error(w, "error rendering image", http.StatusInternalServerError)
+}
+
+// image returns the image of an event at size, rendering it unless its inputs are
+// unchanged since it was cached. Renders wait for a free render slot and stop once ctx
+// is done.
+func (s *server) image(ctx context.Context, id string, size outputSize, format string) (servedImage, error) {
+	eventData, err := s.events.event(id)
+	if err != nil {
+		return servedImage{}, err
+	}
+
+	opts := s.opts
+	opts.Format = format
+	// Hash the files for every request, so that changed inputs change the fingerprint
+	// and are loaded again
+	opts.Manifest = &manifest.Manifest{}
+
+	record := newBufferedRecord()
+	defer record.flush()
+	s.forgetChanged(opts.Manifest, s.templatePath)
+	templatePath := variantTemplatePath(s.templatePath, size, record.renderRecord)
+	s.forgetChanged(opts.Manifest, templatePath)
+	plan, err := planEventImage(eventData, templatePath, s.backgroundPath, s.overlayPaths, record.renderRecord)
+	if err != nil {
+		return servedImage{}, err
+	}
+	s.forgetChanged(opts.Manifest, plan.inputFiles()...)
+	if err := plan.checkOutputSize(size.Spec); err != nil {
+		return servedImage{}, err
+	}
+	fingerprint, err := plan.fingerprint(opts, size.Spec)
+	if err != nil {
+		return servedImage{}, fmt.Errorf("error fingerprinting inputs: %w", err)
+	}
+
+	data, warnings, cached, err := s.cache.get(ctx, fingerprint, imageio.OutputExtension(format), func() ([]byte, []string, error) {
+		release, err := s.acquireRender(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer release()
+		opts.Context = ctx
+		composition, err := plan.render(opts, record.renderRecord)
+		if err != nil {
+			return nil, nil, err
+		}
+		img := plan.resize(composition, size.Spec, opts)
+		name := imageURL(id, size, format)
+		data, err := encodeOutputImage(name, img, eventData, opts, record.renderRecord)
+		return data, record.Warnings, err
+	})
+	if err != nil {
+		return servedImage{}, err
+	}
+	return servedImage{
+		data:        data,
+		fingerprint: fingerprint,
+		inputs:      outputInputs(opts.Manifest, templatePath, s.backgroundPath, s.overlayPaths, record.renderRecord),
+		warnings:    warnings,
+		cached:      cached,
+	}, nil
+}
+
+// forgetChanged drops the files at paths from the template, font and image caches if
+// their hash in m differs from the hash they were last seen with
+func (s *server) forgetChanged(m *manifest.Manifest, paths ...string) {
+	s.loadedMu.Lock()
+	defer s.loadedMu.Unlock()
+	if s.loaded == nil {
+		s.loaded = make(map[string]string)
+	}
+	for _, path := range paths {
+		sum := m.Hash(path).SHA256
+		if previous, ok := s.loaded[path]; ok && previous != sum {
+			forgetTemplate(path)
+			renderer.ForgetFont(path)
+			s.opts.Images.Forget(path)
+		}
+		s.loaded[path] = sum
+	}
+}
+
+// acquireRender waits for a free render slot, unless ctx is done first, and returns the
+// function releasing it
+func (s *server) acquireRender(ctx context.Context) (func(), error) {
+	select {
+	case s.renders <- struct{}{}:
+		return func() { <-s.renders }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// requestSize returns the output size selected by the width, size or preset query
+// parameter, or the full size if none is given
+func requestSize(query url.Values) (outputSize, error) {
+	full := outputSize{Spec: renderer.ResizeSpec{Density: 1}}
+	var given []string
+	for _, key := range []string{"width", "size", "preset"} {
+		if query.Has(key) {
+			given = append(given, key)
+		}
+	}
+	if len(given) == 0 {
+		return full, nil
+	}
+	if len(given) > 1 {
+		return outputSize{}, fmt.Errorf("only one of width, size and preset may be given, got %s", strings.Join(given, ", "))
+	}
+
+	var size outputSize
+	switch given[0] {
+	case "width":
+		width, err := strconv.Atoi(query.Get("width"))
+		if err != nil || width < 0 {
+			return outputSize{}, fmt.Errorf("invalid width %q", query.Get("width"))
+		}
+		size = full
+		if width > 0 {
+			size.Spec = renderer.ResizeSpec{Mode: renderer.ResizeWidth, Width: width, Density: 1}
+		}
+	case "size":
+		spec, err := renderer.ParseResizeSpec(query.Get("size"))
+		if err != nil {
+			return outputSize{}, err
+		}
+		size = outputSize{Spec: spec}
+	case "preset":
+		preset, err := templates.LookupPreset(query.Get("preset"))
+		if err != nil {
+			return outputSize{}, err
+		}
+		size = presetSize(preset)
+	}
+	if err := checkResizeSpec(size.Spec); err != nil {
+		return outputSize{}, err
+	}
+	return size, nil
+}
+
+// checkResizeSpec reports an error if spec exceeds maxImageDimension on any canvas. The
+// output size also depends on the canvas, and is checked once the image is planned.
+func checkResizeSpec(spec renderer.ResizeSpec) error {
+	density := max(spec.Density, 1)
+	if spec.Width > maxImageDimension || spec.Height > maxImageDimension || density > maxImageDimension ||
+		spec.Width*density > maxImageDimension || spec.Height*density > maxImageDimension {
+		return fmt.Errorf("sizes are limited to %d pixels", maxImageDimension)
+	}
+	return nil
+}
+
+// imageURL returns the path and query under which the server serves an image
+func imageURL(id string, size outputSize, format string) string {
+	path := "/events/" + url.PathEscape(id) + imageio.OutputExtension(format)
+	query := url.Values{}
+	switch {
+	case size.Preset != "":
+		query.Set("preset", size.Preset)
+	case size.Spec.IsFull():
+	case size.Spec.Mode == renderer.ResizeWidth && size.Spec.Density <= 1:
+		query.Set("width", strconv.Itoa(size.Spec.Width))
+	default:
+		query.Set("size", size.Spec.String())
+	}
+	if len(query) == 0 {
+		return path
+	}
+	return path + "?" + query.Encode()
+}
+
+// eventSource holds the events served by the server. Events are reloaded from the
+// events file or URL once they are older than the refresh interval.
+type eventSource struct {
+	file    string
+	refresh time.Duration
+	mu      sync.Mutex
+	events  map[string]types.EventData
+	ids     []string
+	loaded  time.Time
+}
+
+// event returns the event with the given ID
+func (s *eventSource) event(id string) (*types.EventData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	eventData, ok := s.events[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", errEventNotFound, id)
+	}
+	return &eventData, nil
+}
+
+// count returns the number of events
+func (s *eventSource) count() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return 0, err
+	}
+	return len(s.events), nil
+}
+
+// list returns the IDs of the events in the order of the events source
+func (s *eventSource) list() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return append([]string(nil), s.ids...), nil
+}
+
+// load loads the events if they are not loaded yet or stale. If reloading fails, the
+// previously loaded events are kept. The caller must hold s.mu.
+func (s *eventSource) load() error {
+	if s.events != nil && (s.refresh <= 0 || time.Since(s.loaded) <= s.refresh) {
+		return nil
+	}
+	allEventData, err := loadAllEvents(s.file)
+	if err != nil {
+		if s.events == nil {
+			return err
+		}
+		log.Printf("Warning: Keeping the loaded events, reloading failed: %v", err)
+	} else {
+		s.events = make(map[string]types.EventData, len(allEventData))
+		s.ids = s.ids[:0]
+		for _, eventData := range allEventData {
+			s.events[eventData.Title] = eventData
+			s.ids = append(s.ids, eventData.Title)
+		}
+	}
+	s.loaded = time.Now()
+	return nil
+}
+
+// renderCache keeps encoded images by fingerprint in memory and, if dir is set, on
+// disk, up to maxBytes. Concurrent requests for the same image wait for a single
+// render; failed renders are not cached.
+type renderCache struct {
+	dir      string
+	maxBytes int64
+	mu       sync.Mutex
+	entries  map[string]*renderEntry
+	// diskMu serializes removing images from dir
+	diskMu sync.Mutex
+}
+
+// renderEntry is an encoded image, rendered once by the first request for it. done and
+// used, the time of the last request for it, are guarded by the mutex of the cache.
+type renderEntry struct {
+	once     sync.Once
+	data     []byte
+	warnings []string
+	err      error
+	done     bool
+	used     time.Time
+}
+
+// get returns the image cached under key, calling render to create it if it is
+// neither in memory nor in the cache directory. It reports whether the image was cached.
+// If get waited for the render of another request and that request was canceled, it
+// renders the image itself, unless ctx is done as well.
+func (c *renderCache) get(ctx context.Context, key, extension string, render func() ([]byte, []string, error)) ([]byte, []string, bool, error) {
+	for {
+		entry := c.entry(key)
+		rendered := false
+		entry.once.Do(func() {
+			path := ""
+			if c.dir != "" {
+				path = filepath.Join(c.dir, key+extension)
+				if data, err := os.ReadFile(path); err == nil {
+					entry.data = data
+					// Mark the image as recently used, so that it is removed last
+					now := time.Now()
+					os.Chtimes(path, now, now)
+					c.finish(key, entry)
+					return
+				}
+			}
+			rendered = true
+			entry.data, entry.warnings, entry.err = render()
+			if entry.err == nil && path != "" {
+				if err := writeFileAtomic(path, entry.data); err != nil {
+					log.Printf("Warning: Could not cache %s: %v", path, err)
+				}
+				c.trim()
+			}
+			c.finish(key, entry)
+		})
+
+		canceled := errors.Is(entry.err, context.Canceled) || errors.Is(entry.err, context.DeadlineExceeded)
+		if !rendered && canceled && ctx.Err() == nil {
+			continue
+		}
+		return entry.data, entry.warnings, !rendered, entry.err
+	}
+}
+
+// entry returns the entry of key, adding it if there is none. To make room, the least
+// recently used entry that is not rendering is dropped; it is still on disk if there is
+// a cache directory.
+func (c *renderCache) entry(key string) *renderEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]*renderEntry)
+	}
+	entry, ok := c.entries[key]
+	if !ok {
+		if len(c.entries) >= maxCachedImages {
+			var oldest string
+			for other, e := range c.entries {
+				if e.done && (oldest == "" || e.used.Before(c.entries[oldest].used)) {
+					oldest = other
+				}
+			}
+			if oldest != "" {
+				delete(c.entries, oldest)
+			}
+		}
+		entry = &renderEntry{}
+		c.entries[key] = entry
+	}
+	entry.used = time.Now()
+	return entry
+}
+
+// finish marks the entry of key as done. Failed entries are removed before the requests
+// waiting for them see the error, so that they render again if they retry.
+func (c *renderCache) finish(key string, entry *renderEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.done = true
+	if entry.err != nil && c.entries[key] == entry {
+		delete(c.entries, key)
+	}
+}
+
+// trim removes the least recently used images from the cache directory until they take
+// up at most maxBytes
+func (c *renderCache) trim() {
+	c.diskMu.Lock()
+	defer c.diskMu.Unlock()
+	files, err := os.ReadDir(c.dir)
+	if err != nil {
+		log.Printf("Warning: Could not list the cache directory: %v", err)
+		return
+	}
+	var infos []os.FileInfo
+	var total int64
+	for _, file := range files {
+		if !file.Type().IsRegular() || strings.HasSuffix(file.Name(), ".tmp") {
+			continue
+		}
+		if info, err := file.Info(); err == nil {
+			infos = append(infos, info)
+			total += info.Size()
+		}
+	}
+	if total <= c.maxBytes {
+		return
+	}
+	slices.SortFunc(infos, func(a, b os.FileInfo) int {
+		return a.ModTime().Compare(b.ModTime())
+	})
+	for _, info := range infos {
+		if total <= c.maxBytes {
+			break
+		}
+		if err := os.Remove(filepath.Join(c.dir, info.Name())); err != nil {
+			log.Printf("Warning: Could not remove %s from the cache: %v", info.Name(), err)
+			continue
+		}
+		total -= info.Size()
+	}
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it into
+// place, so that readers never see a partially written file
+func writeFileAtomic(path string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
@@ -0,0 +1,74 @@
+package imageio
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+)
+
+// BudgetResult is an image encoded to fit a file-size budget
+type BudgetResult struct {
+	Data    []byte
+	Quality int
+	// Fits is false when even the lowest quality exceeds the budget; Data then
+	// holds the smallest encoding that was found.
+	Fits bool
+}
+
+// EncodeWithinBytes encodes img as JPEG with the highest quality whose output is at
+// most maxBytes long. The configured quality (or DefaultJPEGQuality) is the upper
+// bound of the search, so a budget never raises the quality above the configured one.
+func EncodeWithinBytes(img image.Image, opts EncodeOptions, maxBytes int) (BudgetResult, error) {
+	if opts.Format != "" && opts.Format != "jpeg" {
+		return BudgetResult{}, fmt.Errorf("a file-size budget needs jpeg output, not %s", opts.Format)
+	}
+	opts.Format = "jpeg"
+
+	maxQuality := opts.Quality
+	if maxQuality <= 0 {
+		maxQuality = DefaultJPEGQuality
+	}
+
+	encodeAt := func(quality int) ([]byte, error) {
+		var buf bytes.Buffer
+		opts.Quality = quality
+		if err := Encode(&buf, img, opts); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	data, err := encodeAt(maxQuality)
+	if err != nil {
+		return BudgetResult{}, err
+	}
+	if len(data) <= maxBytes {
+		return BudgetResult{Data: data, Quality: maxQuality, Fits: true}, nil
+	}
+
+	// File size grows with quality, so binary search for the highest quality that fits
+	best := BudgetResult{}
+	lo, hi := 1, maxQuality-1
+	for lo <= hi {
+		quality := (lo + hi) / 2
+		data, err := encodeAt(quality)
+		if err != nil {
+			return BudgetResult{}, err
+		}
+		if len(data) <= maxBytes {
+			best = BudgetResult{Data: data, Quality: quality, Fits: true}
+			lo = quality + 1
+		} else {
+			hi = quality - 1
+		}
+	}
+	if best.Fits {
+		return best, nil
+	}
+
+	data, err = encodeAt(1)
+	if err != nil {
+		return BudgetResult{}, err
+	}
+	return BudgetResult{Data: data, Quality: 1, Fits: false}, nil
+}
@@ -0,0 +1,56 @@
+package imageio
+
+import (
+	"image"
+	"sync"
+)
+
+// Cache keeps decoded images in memory so that images used by many outputs, such as
+// the background and overlays, are only decoded once per run. It is safe for
+// concurrent use; callers must treat the returned images as read-only.
+type Cache struct {
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+// cacheEntry is a decoded image, loaded once by the first caller
+type cacheEntry struct {
+	once sync.Once
+	img  image.Image
+	info Info
+	err  error
+}
+
+// Load returns the decoded image at path, loading it on first use.
+// A nil cache loads the image without caching it.
+func (c *Cache) Load(path string) (image.Image, Info, error) {
+	if c == nil {
+		return LoadWithInfo(path)
+	}
+
+	c.mu.Lock()
+	if c.entries == nil {
+		c.entries = make(map[string]*cacheEntry)
+	}
+	entry, ok := c.entries[path]
+	if !ok {
+		entry = &cacheEntry{}
+		c.entries[path] = entry
+	}
+	c.mu.Unlock()
+
+	entry.once.Do(func() {
+		entry.img, entry.info, entry.err = LoadWithInfo(path)
+	})
+	return entry.img, entry.info, entry.err
+}
+
+// Forget drops the image at path from the cache, so that it is decoded again on next use
+func (c *Cache) Forget(path string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, path)
+}
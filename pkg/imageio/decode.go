@@ -0,0 +1,174 @@
+package imageio
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	// Register decoders for every supported format with image.Decode
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// MaxDownloadSize is the largest image accepted from a URL
+const MaxDownloadSize = 32 << 20
+
+// signature describes the magic bytes that identify an image format.
+// A '?' in magic matches any byte.
+type signature struct {
+	format string
+	magic  string
+}
+
+var signatures = []signature{
+	{"png", "\x89PNG\r\n\x1a\n"},
+	{"jpeg", "\xff\xd8\xff"},
+	{"gif", "GIF87a"},
+	{"gif", "GIF89a"},
+	{"webp", "RIFF????WEBP"},
+	{"bmp", "BM"},
+	{"tiff", "II*\x00"},
+	{"tiff", "MM\x00*"},
+}
+
+// extensions maps format names to the file extension used when saving them
+var extensions = map[string]string{
+	"png":  ".png",
+	"jpeg": ".jpg",
+	"gif":  ".gif",
+	"webp": ".webp",
+	"bmp":  ".bmp",
+	"tiff": ".tiff",
+}
+
+// Sniff returns the format of an image from its first bytes: "png", "jpeg", "gif",
+// "webp", "bmp" or "tiff". It returns an empty string for unsupported data.
+func Sniff(header []byte) string {
+	for _, sig := range signatures {
+		if matchMagic(header, sig.magic) {
+			return sig.format
+		}
+	}
+	return ""
+}
+
+// matchMagic reports whether header starts with magic, where '?' matches any byte
+func matchMagic(header []byte, magic string) bool {
+	if len(header) < len(magic) {
+		return false
+	}
+	for i := 0; i < len(magic); i++ {
+		if magic[i] != '?' && header[i] != magic[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Extension returns the file extension for a format returned by Sniff, or an empty string
+func Extension(format string) string {
+	return extensions[format]
+}
+
+// Info describes how a loaded image was interpreted.
+// Orientation is the EXIF orientation that was applied to make the image upright (1 means none).
+type Info struct {
+	Format      string
+	Orientation int
+}
+
+// Decode decodes an image in any supported format, detected from its magic bytes
+// rather than a file extension or Content-Type. JPEG images are rotated and flipped
+// according to their EXIF orientation.
+func Decode(r io.Reader) (image.Image, Info, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, Info{}, fmt.Errorf("failed to read image: %w", err)
+	}
+	return decodeBytes(data)
+}
+
+// decodeBytes decodes an in-memory image, see Decode
+func decodeBytes(data []byte) (image.Image, Info, error) {
+	info := Info{Format: Sniff(data), Orientation: 1}
+	if info.Format == "" {
+		return nil, info, fmt.Errorf("unsupported image format (supported: png, jpeg, gif, webp, bmp, tiff)")
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, info, fmt.Errorf("failed to decode %s image: %w", info.Format, err)
+	}
+
+	if info.Format == "jpeg" {
+		info.Orientation = jpegOrientation(data)
+		img = applyOrientation(img, info.Orientation)
+	}
+	return img, info, nil
+}
+
+// Load loads an image from a local file or an http(s) URL
+func Load(path string) (image.Image, error) {
+	img, _, err := LoadWithInfo(path)
+	return img, err
+}
+
+// LoadWithInfo loads an image from a local file or an http(s) URL and reports how it was decoded
+func LoadWithInfo(path string) (image.Image, Info, error) {
+	var data []byte
+	var err error
+	if IsURL(path) {
+		data, err = Download(path, 10*time.Second)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, Info{}, err
+	}
+
+	img, info, err := decodeBytes(data)
+	if err != nil {
+		return nil, info, fmt.Errorf("%s: %w", path, err)
+	}
+	return img, info, nil
+}
+
+// Download fetches the raw bytes of an image from an http(s) URL, up to MaxDownloadSize
+func Download(url string, timeout time.Duration) ([]byte, error) {
+	client := &http.Client{
+		Timeout: timeout,
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download image from %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download image from %s: HTTP %d", url, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxDownloadSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image from %s: %w", url, err)
+	}
+	if len(data) > MaxDownloadSize {
+		return nil, fmt.Errorf("image at %s exceeds %d bytes", url, MaxDownloadSize)
+	}
+	return data, nil
+}
+
+// IsURL reports whether path is an http(s) URL rather than a local file
+func IsURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
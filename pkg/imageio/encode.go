@@ -0,0 +1,209 @@
+package imageio
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go-image-generator/pkg/imageio/internal/jpeg"
+)
+
+// DefaultJPEGQuality is the JPEG quality used when none is configured
+const DefaultJPEGQuality = 92
+
+// DefaultSubsampling is the JPEG chroma subsampling used when none is configured
+const DefaultSubsampling = "4:2:0"
+
+// subsamplings maps the supported JPEG chroma subsampling names to encoder settings
+var subsamplings = map[string]jpeg.Subsampling{
+	"4:2:0": jpeg.Subsampling420,
+	"4:2:2": jpeg.Subsampling422,
+	"4:4:4": jpeg.Subsampling444,
+}
+
+// EncodeOptions controls how an image is written.
+// Format is one of the registered output formats ("jpeg", "png", "gif", ...).
+// Quality (1-100) and Subsampling ("4:2:0", "4:2:2" or "4:4:4") only apply to JPEG.
+// Metadata is embedded in JPEG and PNG output and ignored for other formats.
+type EncodeOptions struct {
+	Format      string
+	Quality     int
+	Subsampling string
+	Metadata    *Metadata
+}
+
+// EncodeFunc writes img to w in a specific format
+type EncodeFunc func(w io.Writer, img image.Image, opts EncodeOptions) error
+
+// encoder is a registered output format
+type encoder struct {
+	extension   string
+	transparent bool
+	encode      EncodeFunc
+}
+
+var encoders = map[string]encoder{
+	"jpeg": {extension: ".jpg", transparent: false, encode: encodeJPEG},
+	"png":  {extension: ".png", transparent: true, encode: encodePNG},
+	"gif":  {extension: ".gif", transparent: true, encode: encodeGIF},
+}
+
+// formatAliases maps alternative names and file extensions to format names
+var formatAliases = map[string]string{
+	"jpg":  "jpeg",
+	"jpeg": "jpeg",
+	"png":  "png",
+	"gif":  "gif",
+	"webp": "webp",
+}
+
+// RegisterEncoder adds an output format, e.g. WebP when an encoder is available in the build.
+// transparent reports whether the format keeps the alpha channel.
+func RegisterEncoder(format, extension string, transparent bool, encode EncodeFunc) {
+	encoders[format] = encoder{extension: extension, transparent: transparent, encode: encode}
+	formatAliases[format] = format
+	formatAliases[strings.TrimPrefix(extension, ".")] = format
+}
+
+// ParseFormat returns the canonical name of an output format such as "jpg" or "PNG".
+// It fails for unknown formats and for formats without an available encoder.
+func ParseFormat(name string) (string, error) {
+	format, ok := formatAliases[strings.ToLower(strings.TrimPrefix(name, "."))]
+	if !ok {
+		return "", fmt.Errorf("unknown output format %q (available: %s)", name, strings.Join(Formats(), ", "))
+	}
+	if _, ok := encoders[format]; !ok {
+		return "", fmt.Errorf("no %s encoder is available in this build (available: %s)", format, strings.Join(Formats(), ", "))
+	}
+	return format, nil
+}
+
+// ParseSubsampling validates a JPEG chroma subsampling name such as "4:4:4".
+// The separators may be omitted, e.g. "444".
+func ParseSubsampling(name string) (string, error) {
+	if len(name) == 3 {
+		name = name[:1] + ":" + name[1:2] + ":" + name[2:]
+	}
+	if _, ok := subsamplings[name]; !ok {
+		return "", fmt.Errorf("unknown chroma subsampling %q (available: 4:2:0, 4:2:2, 4:4:4)", name)
+	}
+	return name, nil
+}
+
+// FormatFromPath returns the output format for the extension of path, or an empty string if it is not an image extension
+func FormatFromPath(path string) string {
+	return formatAliases[strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))]
+}
+
+// Formats returns the names of all output formats with an available encoder
+func Formats() []string {
+	var names []string
+	for name := range encoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// OutputExtension returns the file extension used for an output format
+func OutputExtension(format string) string {
+	return encoders[format].extension
+}
+
+// SupportsTransparency reports whether an output format keeps the alpha channel
+func SupportsTransparency(format string) bool {
+	return encoders[format].transparent
+}
+
+// Encode writes img to w using the configured format, defaulting to JPEG
+func Encode(w io.Writer, img image.Image, opts EncodeOptions) error {
+	if opts.Format == "" {
+		opts.Format = "jpeg"
+	}
+	enc, ok := encoders[opts.Format]
+	if !ok {
+		return fmt.Errorf("no encoder available for output format %q", opts.Format)
+	}
+	if opts.Metadata == nil {
+		return enc.encode(w, img, opts)
+	}
+
+	var buf bytes.Buffer
+	if err := enc.encode(&buf, img, opts); err != nil {
+		return err
+	}
+	data, err := embedMetadata(opts.Format, buf.Bytes(), *opts.Metadata)
+	if err != nil {
+		return fmt.Errorf("error embedding metadata: %w", err)
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Save writes img to filePath. Without an explicit format, it is inferred from the file extension.
+func Save(filePath string, img image.Image, opts EncodeOptions) error {
+	if opts.Format == "" {
+		opts.Format = FormatFromPath(filePath)
+	}
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	if err := Encode(file, img, opts); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func encodeJPEG(w io.Writer, img image.Image, opts EncodeOptions) error {
+	quality := opts.Quality
+	if quality <= 0 {
+		quality = DefaultJPEGQuality
+	}
+	subsampling, ok := subsamplings[opts.Subsampling]
+	if !ok && opts.Subsampling != "" {
+		return fmt.Errorf("unknown chroma subsampling %q", opts.Subsampling)
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: quality, Subsampling: subsampling})
+}
+
+func encodePNG(w io.Writer, img image.Image, opts EncodeOptions) error {
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	return encoder.Encode(w, img)
+}
+
+// encodeGIF writes a single-frame GIF using the web-safe Plan 9 palette with dithering.
+// Mostly transparent pixels are mapped to a dedicated transparent palette entry.
+func encodeGIF(w io.Writer, img image.Image, opts EncodeOptions) error {
+	pal := make(color.Palette, 0, 256)
+	pal = append(pal, palette.Plan9[:255]...)
+	pal = append(pal, color.Transparent)
+	transparentIndex := uint8(len(pal) - 1)
+
+	b := img.Bounds()
+	paletted := image.NewPaletted(b, pal[:255])
+	draw.FloydSteinberg.Draw(paletted, b, img, b.Min)
+	paletted.Palette = pal
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a < 0x8000 {
+				paletted.SetColorIndex(x, y, transparentIndex)
+			}
+		}
+	}
+
+	return gif.Encode(w, paletted, &gif.Options{NumColors: len(pal)})
+}
@@ -0,0 +1,185 @@
+package imageio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"html"
+	"strings"
+)
+
+// Metadata describes an image for EXIF and XMP fields
+type Metadata struct {
+	Title       string
+	Description string
+	Creator     string
+	Copyright   string
+}
+
+// maxSegmentSize is the largest payload of a JPEG marker segment
+const maxSegmentSize = 0xffff - 2
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	xmpHeader  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+)
+
+// embedMetadata adds metadata to an encoded image. JPEG images get EXIF and XMP
+// APP1 segments, PNG images get iTXt chunks. Other formats are returned unchanged.
+func embedMetadata(format string, data []byte, md Metadata) ([]byte, error) {
+	switch format {
+	case "jpeg":
+		return embedJPEGMetadata(data, md)
+	case "png":
+		return embedPNGMetadata(data, md)
+	default:
+		return data, nil
+	}
+}
+
+// embedJPEGMetadata inserts EXIF and XMP segments directly after the SOI marker
+func embedJPEGMetadata(data []byte, md Metadata) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, fmt.Errorf("not a JPEG stream")
+	}
+	exif := append(append([]byte(nil), exifHeader...), exifTIFF(md)...)
+	xmp := append(append([]byte(nil), xmpHeader...), xmpPacket(md)...)
+	if len(exif) > maxSegmentSize || len(xmp) > maxSegmentSize {
+		return nil, fmt.Errorf("metadata is too large for a JPEG segment")
+	}
+
+	var out bytes.Buffer
+	out.Write(data[:2])
+	for _, payload := range [][]byte{exif, xmp} {
+		out.Write([]byte{0xff, 0xe1})
+		binary.Write(&out, binary.BigEndian, uint16(len(payload)+2))
+		out.Write(payload)
+	}
+	out.Write(data[2:])
+	return out.Bytes(), nil
+}
+
+// exifTIFF returns a big-endian TIFF structure with a single IFD holding the
+// ImageDescription, Artist and Copyright tags
+func exifTIFF(md Metadata) []byte {
+	type entry struct {
+		tag   uint16
+		value string
+	}
+	var entries []entry
+	for _, e := range []entry{
+		{0x010e, md.Description},
+		{0x013b, md.Creator},
+		{0x8298, md.Copyright},
+	} {
+		if e.value != "" {
+			entries = append(entries, e)
+		}
+	}
+
+	const headerSize = 8
+	ifdSize := 2 + 12*len(entries) + 4
+	dataOffset := headerSize + ifdSize
+
+	var ifd, values bytes.Buffer
+	binary.Write(&ifd, binary.BigEndian, uint16(len(entries)))
+	for _, e := range entries {
+		value := append([]byte(e.value), 0)
+		binary.Write(&ifd, binary.BigEndian, e.tag)
+		binary.Write(&ifd, binary.BigEndian, uint16(2)) // ASCII
+		binary.Write(&ifd, binary.BigEndian, uint32(len(value)))
+		if len(value) <= 4 {
+			ifd.Write(append(value, make([]byte, 4-len(value))...))
+			continue
+		}
+		binary.Write(&ifd, binary.BigEndian, uint32(dataOffset+values.Len()))
+		values.Write(value)
+		if values.Len()%2 == 1 {
+			values.WriteByte(0)
+		}
+	}
+	binary.Write(&ifd, binary.BigEndian, uint32(0)) // no next IFD
+
+	var out bytes.Buffer
+	out.WriteString("MM\x00\x2a")
+	binary.Write(&out, binary.BigEndian, uint32(headerSize))
+	out.Write(ifd.Bytes())
+	out.Write(values.Bytes())
+	return out.Bytes()
+}
+
+// xmpPacket returns an XMP packet with the Dublin Core title, description, creator and rights
+func xmpPacket(md Metadata) []byte {
+	var b strings.Builder
+	b.WriteString("<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	b.WriteString("<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n")
+	b.WriteString(" <rdf:RDF xmlns:rdf=\"http://www.w3.org/1999/02/22-rdf-syntax-ns#\">\n")
+	b.WriteString("  <rdf:Description rdf:about=\"\" xmlns:dc=\"http://purl.org/dc/elements/1.1/\">\n")
+	writeAlt := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "   <dc:%s><rdf:Alt><rdf:li xml:lang=\"x-default\">%s</rdf:li></rdf:Alt></dc:%s>\n", name, html.EscapeString(value), name)
+		}
+	}
+	writeAlt("title", md.Title)
+	writeAlt("description", md.Description)
+	if md.Creator != "" {
+		fmt.Fprintf(&b, "   <dc:creator><rdf:Seq><rdf:li>%s</rdf:li></rdf:Seq></dc:creator>\n", html.EscapeString(md.Creator))
+	}
+	writeAlt("rights", md.Copyright)
+	b.WriteString("  </rdf:Description>\n")
+	b.WriteString(" </rdf:RDF>\n")
+	b.WriteString("</x:xmpmeta>\n")
+	b.WriteString("<?xpacket end=\"w\"?>")
+	return []byte(b.String())
+}
+
+// embedPNGMetadata inserts iTXt chunks with the standard PNG text keywords and
+// an XMP packet directly after the IHDR chunk
+func embedPNGMetadata(data []byte, md Metadata) ([]byte, error) {
+	const signatureSize = 8
+	if len(data) < signatureSize+8 || string(data[12:16]) != "IHDR" {
+		return nil, fmt.Errorf("not a PNG stream")
+	}
+	ihdrEnd := signatureSize + 12 + int(binary.BigEndian.Uint32(data[8:12]))
+	if ihdrEnd > len(data) {
+		return nil, fmt.Errorf("truncated PNG stream")
+	}
+
+	var out bytes.Buffer
+	out.Write(data[:ihdrEnd])
+	for _, text := range []struct{ keyword, value string }{
+		{"Title", md.Title},
+		{"Description", md.Description},
+		{"Author", md.Creator},
+		{"Copyright", md.Copyright},
+		{"XML:com.adobe.xmp", string(xmpPacket(md))},
+	} {
+		if text.value != "" {
+			writePNGChunk(&out, "iTXt", iTXt(text.keyword, text.value))
+		}
+	}
+	out.Write(data[ihdrEnd:])
+	return out.Bytes(), nil
+}
+
+// iTXt returns the data of an uncompressed international text chunk
+func iTXt(keyword, text string) []byte {
+	var b bytes.Buffer
+	b.WriteString(keyword)
+	// Null separator, no compression, compression method, empty language tag and translated keyword
+	b.Write([]byte{0, 0, 0, 0, 0})
+	b.WriteString(text)
+	return b.Bytes()
+}
+
+// writePNGChunk writes a chunk with its length and CRC
+func writePNGChunk(out *bytes.Buffer, chunkType string, data []byte) {
+	binary.Write(out, binary.BigEndian, uint32(len(data)))
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(chunkType))
+	crc.Write(data)
+	out.WriteString(chunkType)
+	out.Write(data)
+	binary.Write(out, binary.BigEndian, crc.Sum32())
+}
@@ -0,0 +1,224 @@
+// Package manifest records the outputs of a generation run and the inputs they
+// were rendered from, so that other tools can consume the results.
+package manifest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// FileName is the name of the manifest file in the output directory
+const FileName = "manifest.json"
+
+// File is an input or output file with its content hash
+type File struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// Inputs lists the files an output was rendered from
+type Inputs struct {
+	Template      *File  `json:"template,omitempty"`
+	Background    *File  `json:"background,omitempty"`
+	Overlays      []File `json:"overlays,omitempty"`
+	SpeakerImages []File `json:"speakerImages,omitempty"`
+}
+
+// Output describes one generated image. Failed outputs have Error set and no
+// size or hash. Fingerprint identifies the inputs and settings the image was
+// rendered from, so that unchanged outputs can be skipped by later runs.
+type Output struct {
+	EventID     string   `json:"eventId"`
+	Variant     string   `json:"variant"`
+	Path        string   `json:"path"`
+	Fingerprint string   `json:"fingerprint,omitempty"`
+	Width       int      `json:"width,omitempty"`
+	Height      int      `json:"height,omitempty"`
+	Bytes       int64    `json:"bytes,omitempty"`
+	SHA256      string   `json:"sha256,omitempty"`
+	AltText     string   `json:"altText,omitempty"`
+	Inputs      Inputs   `json:"inputs"`
+	Warnings    []string `json:"warnings,omitempty"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// Manifest collects the outputs of a run. It is safe for concurrent use.
+type Manifest struct {
+	mu       sync.Mutex
+	outputs  []Output
+	hashes   map[string]string
+	previous map[string]Output
+}
+
+// document is the JSON layout of the manifest file
+type document struct {
+	Outputs []Output `json:"outputs"`
+}
+
+// Add records an output, replacing an earlier record for the same path
+func (m *Manifest) Add(output Output) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.outputs {
+		if m.outputs[i].Path == output.Path {
+			m.outputs[i] = output
+			return
+		}
+	}
+	m.outputs = append(m.outputs, output)
+}
+
+// Load reads the outputs of a previous run from the manifest at path, for use by
+// Unchanged. A missing manifest is not an error.
+func (m *Manifest) Load(path string) error {
+	outputs, err := Read(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.previous = make(map[string]Output, len(outputs))
+	for _, output := range outputs {
+		m.previous[output.Path] = output
+	}
+	return nil
+}
+
+// Unchanged returns the previous record for path if it was rendered with the same
+// fingerprint and the file on disk still has the recorded hash
+func (m *Manifest) Unchanged(path, fingerprint string) (Output, bool) {
+	m.mu.Lock()
+	output, ok := m.previous[path]
+	m.mu.Unlock()
+	if !ok || output.Error != "" || output.Fingerprint == "" || output.Fingerprint != fingerprint {
+		return Output{}, false
+	}
+	sum, err := HashFile(path)
+	if err != nil || sum != output.SHA256 {
+		return Output{}, false
+	}
+	return output, true
+}
+
+// Outputs returns the recorded outputs sorted by event ID, variant and path
+func (m *Manifest) Outputs() []Output {
+	m.mu.Lock()
+	outputs := append([]Output(nil), m.outputs...)
+	m.mu.Unlock()
+	sortOutputs(outputs)
+	return outputs
+}
+
+// Hash returns path with the SHA-256 of its content. Hashes are cached for the
+// lifetime of the manifest, since inputs such as the background are shared by
+// all outputs. Files that cannot be read, e.g. URLs, are returned without a hash.
+func (m *Manifest) Hash(path string) File {
+	m.mu.Lock()
+	sum, ok := m.hashes[path]
+	m.mu.Unlock()
+	if ok {
+		return File{Path: path, SHA256: sum}
+	}
+
+	sum, err := HashFile(path)
+	if err != nil {
+		return File{Path: path}
+	}
+
+	m.mu.Lock()
+	if m.hashes == nil {
+		m.hashes = make(map[string]string)
+	}
+	m.hashes[path] = sum
+	m.mu.Unlock()
+	return File{Path: path, SHA256: sum}
+}
+
+// Write saves the manifest to path. Outputs recorded by earlier runs in an existing
+// manifest are kept if their file still exists and was not regenerated in this run,
+// so that runs for different widths share one manifest.
+func (m *Manifest) Write(path string) error {
+	outputs := m.Outputs()
+
+	previous, err := Read(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	current := make(map[string]bool, len(outputs))
+	for _, output := range outputs {
+		current[output.Path] = true
+	}
+	for _, output := range previous {
+		if current[output.Path] {
+			continue
+		}
+		if _, err := os.Stat(output.Path); err == nil {
+			outputs = append(outputs, output)
+		}
+	}
+	sortOutputs(outputs)
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(document{Outputs: outputs}); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// Read loads the outputs recorded in the manifest at path
+func Read(path string) ([]Output, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("error parsing manifest %s: %w", path, err)
+	}
+	return doc.Outputs, nil
+}
+
+// HashFile returns the hex-encoded SHA-256 of the content of path
+func HashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// sortOutputs orders outputs by event ID (numerically where possible), variant and path
+func sortOutputs(outputs []Output) {
+	sort.SliceStable(outputs, func(i, j int) bool {
+		a, b := outputs[i], outputs[j]
+		if a.EventID != b.EventID {
+			if len(a.EventID) != len(b.EventID) {
+				return len(a.EventID) < len(b.EventID)
+			}
+			return a.EventID < b.EventID
+		}
+		if a.Variant != b.Variant {
+			return a.Variant < b.Variant
+		}
+		return a.Path < b.Path
+	})
+}
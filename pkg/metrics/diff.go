@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// JND is the CIE76 color difference commonly taken as just noticeable
+const JND = 2.3
+
+// heatmapMaxDeltaE is the color difference shown in full red on a heatmap
+const heatmapMaxDeltaE = 25
+
+// Difference is the result of comparing two images pixel by pixel
+type Difference struct {
+	// Changed is the share of pixels whose color difference exceeds the threshold, from 0 to 1
+	Changed float64
+	// MaxDeltaE is the largest color difference of a pixel
+	MaxDeltaE float64
+	// Bounds encloses the changed pixels, empty if there are none
+	Bounds image.Rectangle
+	// Heatmap shows b dimmed to gray, with the changed pixels from yellow to red by
+	// their color difference
+	Heatmap *image.RGBA
+}
+
+// Diff compares a and b by the CIE76 color difference of each pixel, counting pixels
+// that differ by more than threshold as changed. The images must have the same size.
+func Diff(a, b image.Image, threshold float64) (Difference, error) {
+	if a.Bounds().Size() != b.Bounds().Size() {
+		return Difference{}, fmt.Errorf("image sizes differ: %v and %v", a.Bounds().Size(), b.Bounds().Size())
+	}
+	ba, bb := a.Bounds(), b.Bounds()
+	w, h := ba.Dx(), ba.Dy()
+	diff := Difference{Heatmap: image.NewRGBA(image.Rect(0, 0, w, h))}
+	changed := 0
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			ca := color.NRGBAModel.Convert(a.At(ba.Min.X+x, ba.Min.Y+y)).(color.NRGBA)
+			cb := color.NRGBAModel.Convert(b.At(bb.Min.X+x, bb.Min.Y+y)).(color.NRGBA)
+			d := deltaE(ca, cb)
+			diff.MaxDeltaE = math.Max(diff.MaxDeltaE, d)
+			if d > threshold {
+				changed++
+				diff.Bounds = diff.Bounds.Union(image.Rect(x, y, x+1, y+1))
+				// Yellow at the threshold, red from heatmapMaxDeltaE
+				t := math.Min(1, (d-threshold)/math.Max(heatmapMaxDeltaE-threshold, 1))
+				diff.Heatmap.SetRGBA(x, y, color.RGBA{0xff, uint8(0xff * (1 - t)), 0, 0xff})
+				continue
+			}
+			gray := uint8(0.299*float64(cb.R)/3 + 0.587*float64(cb.G)/3 + 0.114*float64(cb.B)/3)
+			diff.Heatmap.SetRGBA(x, y, color.RGBA{gray, gray, gray, 0xff})
+		}
+	}
+	if w*h > 0 {
+		diff.Changed = float64(changed) / float64(w*h)
+	}
+	return diff, nil
+}
+
+// DeltaE returns the CIE76 color difference of a and b, the distance of their CIELAB
+// colors under the D65 white point. Alpha is ignored.
+func DeltaE(a, b color.Color) float64 {
+	return deltaE(color.NRGBAModel.Convert(a).(color.NRGBA), color.NRGBAModel.Convert(b).(color.NRGBA))
+}
+
+func deltaE(a, b color.NRGBA) float64 {
+	if a.R == b.R && a.G == b.G && a.B == b.B {
+		return 0
+	}
+	la, aa, bba := lab(a)
+	lb, ab, bbb := lab(b)
+	return math.Sqrt((la-lb)*(la-lb) + (aa-ab)*(aa-ab) + (bba-bbb)*(bba-bbb))
+}
+
+// srgbToLinear maps 8-bit sRGB values to linear light
+var srgbToLinear = func() (table [256]float64) {
+	for i := range table {
+		s := float64(i) / 255
+		if s <= 0.04045 {
+			table[i] = s / 12.92
+		} else {
+			table[i] = math.Pow((s+0.055)/1.055, 2.4)
+		}
+	}
+	return table
+}()
+
+// lab converts c to CIELAB under the D65 white point
+func lab(c color.NRGBA) (l, a, b float64) {
+	r, g, bl := srgbToLinear[c.R], srgbToLinear[c.G], srgbToLinear[c.B]
+	x := (0.4124*r + 0.3576*g + 0.1805*bl) / 0.95047
+	y := 0.2126*r + 0.7152*g + 0.0722*bl
+	z := (0.0193*r + 0.1192*g + 0.9505*bl) / 1.08883
+	f := func(t float64) float64 {
+		if t > 216.0/24389 {
+			return math.Cbrt(t)
+		}
+		return (24389.0/27*t + 16) / 116
+	}
+	fx, fy, fz := f(x), f(y), f(z)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
@@ -0,0 +1,125 @@
+// Package metrics compares rendered images, e.g. a lossy output against the
+// lossless render it was encoded from.
+package metrics
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+const (
+	// ssimWindow is the side length of the square windows SSIM is computed over
+	ssimWindow = 8
+	// ssimStep is the distance between neighbouring windows
+	ssimStep = 4
+)
+
+// SSIM constants for 8-bit values, from Wang et al. (2004)
+var (
+	ssimC1 = math.Pow(0.01*255, 2)
+	ssimC2 = math.Pow(0.03*255, 2)
+)
+
+// SSIM returns the mean structural similarity of the luma of a and b, from 0 to 1
+// where 1 means identical. The images must have the same size.
+func SSIM(a, b image.Image) (float64, error) {
+	la, lb, err := lumaPlanes(a, b)
+	if err != nil {
+		return 0, err
+	}
+	w, h := a.Bounds().Dx(), a.Bounds().Dy()
+	if w == 0 || h == 0 {
+		return 1, nil
+	}
+	if w < ssimWindow || h < ssimWindow {
+		return windowSSIM(la, lb, w, 0, 0, w, h), nil
+	}
+
+	var sum float64
+	var count int
+	for y := 0; y+ssimWindow <= h; y += ssimStep {
+		for x := 0; x+ssimWindow <= w; x += ssimStep {
+			sum += windowSSIM(la, lb, w, x, y, ssimWindow, ssimWindow)
+			count++
+		}
+	}
+	return sum / float64(count), nil
+}
+
+// windowSSIM computes SSIM for one window of two luma planes with the given row stride
+func windowSSIM(la, lb []float64, stride, x0, y0, w, h int) float64 {
+	n := float64(w * h)
+	var sumA, sumB, sumAA, sumBB, sumAB float64
+	for y := y0; y < y0+h; y++ {
+		for x := x0; x < x0+w; x++ {
+			va := la[y*stride+x]
+			vb := lb[y*stride+x]
+			sumA += va
+			sumB += vb
+			sumAA += va * va
+			sumBB += vb * vb
+			sumAB += va * vb
+		}
+	}
+	meanA := sumA / n
+	meanB := sumB / n
+	varA := sumAA/n - meanA*meanA
+	varB := sumBB/n - meanB*meanB
+	cov := sumAB/n - meanA*meanB
+	return ((2*meanA*meanB + ssimC1) * (2*cov + ssimC2)) /
+		((meanA*meanA + meanB*meanB + ssimC1) * (varA + varB + ssimC2))
+}
+
+// PSNR returns the peak signal-to-noise ratio of b against a in decibels, over the
+// RGB channels. Identical images return +Inf. Images of different sizes return 0.
+func PSNR(a, b image.Image) float64 {
+	if a.Bounds().Size() != b.Bounds().Size() {
+		return 0
+	}
+	mse := MSE(a, b)
+	if mse == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(255*255/mse)
+}
+
+// MSE returns the mean squared error between the RGB channels of a and b, on a
+// 0-255 scale. Both images must have the same size.
+func MSE(a, b image.Image) float64 {
+	ba, bb := a.Bounds(), b.Bounds()
+	var sum float64
+	for y := 0; y < ba.Dy(); y++ {
+		for x := 0; x < ba.Dx(); x++ {
+			ca := color.NRGBAModel.Convert(a.At(ba.Min.X+x, ba.Min.Y+y)).(color.NRGBA)
+			cb := color.NRGBAModel.Convert(b.At(bb.Min.X+x, bb.Min.Y+y)).(color.NRGBA)
+			dr := float64(ca.R) - float64(cb.R)
+			dg := float64(ca.G) - float64(cb.G)
+			db := float64(ca.B) - float64(cb.B)
+			sum += dr*dr + dg*dg + db*db
+		}
+	}
+	return sum / float64(3*ba.Dx()*ba.Dy())
+}
+
+// lumaPlanes returns the Rec. 601 luma of both images as row-major planes
+func lumaPlanes(a, b image.Image) ([]float64, []float64, error) {
+	if a.Bounds().Size() != b.Bounds().Size() {
+		return nil, nil, fmt.Errorf("image sizes differ: %v and %v", a.Bounds().Size(), b.Bounds().Size())
+	}
+	return lumaPlane(a), lumaPlane(b), nil
+}
+
+// lumaPlane returns the Rec. 601 luma of img as a row-major plane
+func lumaPlane(img image.Image) []float64 {
+	b := img.Bounds()
+	plane := make([]float64, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			plane = append(plane, 0.299*float64(c.R)+0.587*float64(c.G)+0.114*float64(c.B))
+		}
+	}
+	return plane
+}
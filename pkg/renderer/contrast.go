@@ -0,0 +1,87 @@
+package renderer
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"slices"
+)
+
+// maxContrastSamples limits the number of pixels sampled behind a text
+const maxContrastSamples = 20000
+
+// RelativeLuminance returns the WCAG relative luminance of c, from 0 for black to 1 for white
+func RelativeLuminance(c color.Color) float64 {
+	r, g, b, _ := c.RGBA()
+	channel := func(v uint32) float64 {
+		s := float64(v) / 0xffff
+		if s <= 0.04045 {
+			return s / 12.92
+		}
+		return math.Pow((s+0.055)/1.055, 2.4)
+	}
+	return 0.2126*channel(r) + 0.7152*channel(g) + 0.0722*channel(b)
+}
+
+// ContrastRatio returns the WCAG contrast ratio of two relative luminances, from 1 to 21
+func ContrastRatio(a, b float64) float64 {
+	return (max(a, b) + 0.05) / (min(a, b) + 0.05)
+}
+
+// TextContrast returns the contrast ratio of the color hex against the pixels of img in r
+// that it contrasts least with, ignoring the worst tenth, so that a few dark pixels in a
+// light area do not decide. It returns 21 if r holds no pixels of img.
+func TextContrast(img *image.RGBA, r image.Rectangle, hex string) float64 {
+	r = r.Intersect(img.Bounds())
+	if r.Empty() {
+		return 21
+	}
+	text := RelativeLuminance(parseHexColor(hex))
+
+	// Sample a grid of at most maxContrastSamples pixels
+	step := max(1, int(math.Sqrt(float64(r.Dx()*r.Dy())/maxContrastSamples)))
+	var ratios []float64
+	for y := r.Min.Y; y < r.Max.Y; y += step {
+		for x := r.Min.X; x < r.Max.X; x += step {
+			ratios = append(ratios, ContrastRatio(text, RelativeLuminance(img.RGBAAt(x, y))))
+		}
+	}
+	slices.Sort(ratios)
+	return ratios[len(ratios)/10]
+}
+
+// DrawPanel fills r with the color hex at the given opacity, rounding its corners by radius pixels
+func DrawPanel(img *image.RGBA, r image.Rectangle, hex string, opacity float64, radius int) {
+	c := color.RGBAModel.Convert(parseHexColor(hex)).(color.RGBA)
+	a := clampChannel(opacity * 255)
+	fill := color.RGBA{
+		R: uint8(uint32(c.R) * uint32(a) / 255),
+		G: uint8(uint32(c.G) * uint32(a) / 255),
+		B: uint8(uint32(c.B) * uint32(a) / 255),
+		A: a,
+	}
+	radius = min(radius, r.Dx()/2, r.Dy()/2)
+	// Only the part of the panel inside img is drawn, with the corners of the whole panel
+	visible := r.Intersect(img.Bounds())
+	mask := image.NewAlpha(visible)
+	for y := visible.Min.Y; y < visible.Max.Y; y++ {
+		for x := visible.Min.X; x < visible.Max.X; x++ {
+			if insideRoundedRect(x, y, r, radius) {
+				mask.SetAlpha(x, y, color.Alpha{A: 0xff})
+			}
+		}
+	}
+	draw.DrawMask(img, visible, image.NewUniform(fill), image.Point{}, mask, visible.Min, draw.Over)
+}
+
+// insideRoundedRect reports whether the pixel at (x, y) lies in r with corners rounded by radius
+func insideRoundedRect(x, y int, r image.Rectangle, radius int) bool {
+	if radius <= 0 {
+		return true
+	}
+	px, py := float64(x)+0.5, float64(y)+0.5
+	cx := math.Min(math.Max(px, float64(r.Min.X+radius)), float64(r.Max.X-radius))
+	cy := math.Min(math.Max(py, float64(r.Min.Y+radius)), float64(r.Max.Y-radius))
+	return (px-cx)*(px-cx)+(py-cy)*(py-cy) <= float64(radius*radius)
+}
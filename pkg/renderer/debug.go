@@ -0,0 +1,120 @@
+package renderer
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// DebugPen draws the outlines, lines, markers and labels of the layout debug overlay.
+// Strokes and labels grow with the image, one pixel per 960 pixels of width, so that
+// they stay visible once the image is scaled down.
+type DebugPen struct {
+	Img   *image.RGBA
+	Width int
+}
+
+// NewDebugPen returns a pen drawing onto img
+func NewDebugPen(img *image.RGBA) DebugPen {
+	return DebugPen{Img: img, Width: max(1, img.Bounds().Dx()/960)}
+}
+
+// fill paints r in c
+func (p DebugPen) fill(r image.Rectangle, c color.Color) {
+	draw.Draw(p.Img, r.Intersect(p.Img.Bounds()), image.NewUniform(c), image.Point{}, draw.Over)
+}
+
+// Rect outlines r
+func (p DebugPen) Rect(r image.Rectangle, c color.Color) {
+	w := p.Width
+	p.fill(image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+w), c)
+	p.fill(image.Rect(r.Min.X, r.Max.Y-w, r.Max.X, r.Max.Y), c)
+	p.fill(image.Rect(r.Min.X, r.Min.Y+w, r.Min.X+w, r.Max.Y-w), c)
+	p.fill(image.Rect(r.Max.X-w, r.Min.Y+w, r.Max.X, r.Max.Y-w), c)
+}
+
+// HLine draws a horizontal line from x0 to x1 with its top at y
+func (p DebugPen) HLine(x0, x1, y int, c color.Color) {
+	p.fill(image.Rect(x0, y, x1, y+p.Width), c)
+}
+
+// VLine draws a vertical line from y0 to y1 with its left edge at x
+func (p DebugPen) VLine(x, y0, y1 int, c color.Color) {
+	p.fill(image.Rect(x, y0, x+p.Width, y1), c)
+}
+
+// DashedVLine draws a dashed vertical line from y0 to y1 with its left edge at x
+func (p DebugPen) DashedVLine(x, y0, y1 int, c color.Color) {
+	dash := 6 * p.Width
+	for y := y0; y < y1; y += 2 * dash {
+		p.fill(image.Rect(x, y, x+p.Width, min(y+dash, y1)), c)
+	}
+}
+
+// Cross marks pt with a cross of the given arm length
+func (p DebugPen) Cross(pt image.Point, arm int, c color.Color) {
+	w := p.Width
+	p.fill(image.Rect(pt.X-arm, pt.Y-w/2, pt.X+arm+1, pt.Y-w/2+w), c)
+	p.fill(image.Rect(pt.X-w/2, pt.Y-arm, pt.X-w/2+w, pt.Y+arm+1), c)
+}
+
+// Circle outlines the circle inscribed in r
+func (p DebugPen) Circle(r image.Rectangle, c color.Color) {
+	col := color.RGBAModel.Convert(c).(color.RGBA)
+	cx, cy := float64(r.Min.X+r.Max.X)/2, float64(r.Min.Y+r.Max.Y)/2
+	radius := math.Min(float64(r.Dx()), float64(r.Dy())) / 2
+	inner := radius - float64(p.Width)
+	bounds := r.Intersect(p.Img.Bounds())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			dx, dy := float64(x)+0.5-cx, float64(y)+0.5-cy
+			if d := math.Sqrt(dx*dx + dy*dy); d <= radius && d >= inner {
+				p.Img.SetRGBA(x, y, col)
+			}
+		}
+	}
+}
+
+// Label writes text in c on a dark backing with its bottom-left corner at pt, moved
+// inside the image if it would leave it
+func (p DebugPen) Label(pt image.Point, text string, c color.Color) {
+	face := basicfont.Face7x13
+	width := font.MeasureString(face, text).Ceil() + 4
+	height := face.Metrics().Height.Ceil() + 2
+	label := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(label, label.Bounds(), image.NewUniform(color.RGBA{0, 0, 0, 0xb0}), image.Point{}, draw.Src)
+	d := &font.Drawer{Dst: label, Src: image.NewUniform(c), Face: face, Dot: fixed.P(2, face.Metrics().Ascent.Ceil()+1)}
+	d.DrawString(text)
+
+	// Enlarge the label by whole pixels to keep the bitmap font sharp
+	scaled := image.Rect(0, 0, width*p.Width, height*p.Width)
+	at := image.Pt(pt.X, pt.Y-scaled.Dy())
+	b := p.Img.Bounds()
+	at.X = clampInt(at.X, b.Min.X, b.Max.X-scaled.Dx())
+	at.Y = clampInt(at.Y, b.Min.Y, b.Max.Y-scaled.Dy())
+	for y := 0; y < scaled.Dy(); y++ {
+		for x := 0; x < scaled.Dx(); x++ {
+			src := label.RGBAAt(x/p.Width, y/p.Width)
+			dst := image.Pt(at.X+x, at.Y+y)
+			if dst.In(b) {
+				p.Img.SetRGBA(dst.X, dst.Y, blendOver(p.Img.RGBAAt(dst.X, dst.Y), src))
+			}
+		}
+	}
+}
+
+// blendOver composes the premultiplied color src over dst
+func blendOver(dst, src color.RGBA) color.RGBA {
+	a := 255 - uint32(src.A)
+	return color.RGBA{
+		R: uint8(uint32(src.R) + uint32(dst.R)*a/255),
+		G: uint8(uint32(src.G) + uint32(dst.G)*a/255),
+		B: uint8(uint32(src.B) + uint32(dst.B)*a/255),
+		A: uint8(uint32(src.A) + uint32(dst.A)*a/255),
+	}
+}
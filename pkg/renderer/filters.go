@@ -0,0 +1,186 @@
+package renderer
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"go-image-generator/pkg/types"
+)
+
+// applyFilters applies the filter chain to img in place, in the order given.
+//
+// Supported filter types:
+//   - grayscale:  removes color; amount blends with the original (default 1)
+//   - duotone:    maps shadows to colors[0] and highlights to colors[1]; amount blends (default 1)
+//   - brightness: adds amount (-1 to 1) of full intensity to every channel
+//   - contrast:   scales the distance from mid-gray by 1+amount (amount >= -1)
+//   - saturation: scales the distance from gray by 1+amount (-1 removes all color)
+//   - sharpen:    unsharp mask with the given amount (default 1) and blur radius (default 1)
+//   - blur:       gaussian blur with the given radius in pixels (default 2)
+//
+// Once ctx is done, the blurs stop early and applyFilters returns its error.
+func applyFilters(ctx context.Context, img *image.RGBA, filters []types.ImageFilter) error {
+	for _, filter := range filters {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		switch filter.Type {
+		case "grayscale":
+			amount := defaultAmount(filter.Amount, 1)
+			mapPixels(img, func(r, g, b float64) (float64, float64, float64) {
+				l := luminance(r, g, b)
+				return mix(r, l, amount), mix(g, l, amount), mix(b, l, amount)
+			})
+		case "duotone":
+			if len(filter.Colors) != 2 {
+				return fmt.Errorf("duotone filter needs exactly two colors, got %d", len(filter.Colors))
+			}
+			shadow := color.RGBAModel.Convert(parseHexColor(filter.Colors[0])).(color.RGBA)
+			highlight := color.RGBAModel.Convert(parseHexColor(filter.Colors[1])).(color.RGBA)
+			amount := defaultAmount(filter.Amount, 1)
+			mapPixels(img, func(r, g, b float64) (float64, float64, float64) {
+				t := luminance(r, g, b) / 255
+				return mix(r, mix(float64(shadow.R), float64(highlight.R), t), amount),
+					mix(g, mix(float64(shadow.G), float64(highlight.G), t), amount),
+					mix(b, mix(float64(shadow.B), float64(highlight.B), t), amount)
+			})
+		case "brightness":
+			offset := filter.Amount * 255
+			mapPixels(img, func(r, g, b float64) (float64, float64, float64) {
+				return r + offset, g + offset, b + offset
+			})
+		case "contrast":
+			factor := 1 + filter.Amount
+			mapPixels(img, func(r, g, b float64) (float64, float64, float64) {
+				return 128 + (r-128)*factor, 128 + (g-128)*factor, 128 + (b-128)*factor
+			})
+		case "saturation":
+			factor := 1 + filter.Amount
+			mapPixels(img, func(r, g, b float64) (float64, float64, float64) {
+				l := luminance(r, g, b)
+				return l + (r-l)*factor, l + (g-l)*factor, l + (b-l)*factor
+			})
+		case "sharpen":
+			unsharpMask(ctx, img, defaultAmount(filter.Radius, 1), defaultAmount(filter.Amount, 1))
+		case "blur":
+			blurred := gaussianBlur(ctx, img, defaultAmount(filter.Radius, 2))
+			copy(img.Pix, blurred.Pix)
+		default:
+			return fmt.Errorf("unknown image filter type %q", filter.Type)
+		}
+	}
+	return ctx.Err()
+}
+
+// unsharpMask sharpens img in place by adding amount times the difference between
+// the image and a gaussian blur of it with the given radius.
+func unsharpMask(ctx context.Context, img *image.RGBA, radius, amount float64) {
+	if amount == 0 || radius <= 0 {
+		return
+	}
+	blurred := gaussianBlur(ctx, img, radius)
+	for i := 0; i < len(img.Pix); i += 4 {
+		for c := 0; c < 3; c++ {
+			v := float64(img.Pix[i+c])
+			sharpened := v + amount*(v-float64(blurred.Pix[i+c]))
+			// Keep premultiplied color values valid
+			img.Pix[i+c] = clampChannel(math.Min(sharpened, float64(img.Pix[i+3])))
+		}
+	}
+}
+
+// gaussianBlur returns a copy of img blurred with a separable gaussian kernel whose
+// standard deviation is radius/2, so that radius is roughly the visible blur extent.
+// Once ctx is done, the rows not blurred yet are left transparent.
+func gaussianBlur(ctx context.Context, img *image.RGBA, radius float64) *image.RGBA {
+	sigma := math.Max(radius/2, 0.5)
+	half := int(math.Ceil(sigma * 3))
+	kernel := make([]float64, 2*half+1)
+	sum := 0.0
+	for i := range kernel {
+		x := float64(i - half)
+		kernel[i] = math.Exp(-x * x / (2 * sigma * sigma))
+		sum += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+
+	b := img.Bounds()
+	tmp := image.NewRGBA(b)
+	out := image.NewRGBA(b)
+	convolve(ctx, tmp, img, kernel, 1, 0)
+	convolve(ctx, out, tmp, kernel, 0, 1)
+	return out
+}
+
+// convolve applies a one-dimensional kernel along the direction (dx, dy), clamping at the
+// image edges, row by row until ctx is done
+func convolve(ctx context.Context, dst, src *image.RGBA, kernel []float64, dx, dy int) {
+	b := src.Bounds()
+	half := len(kernel) / 2
+	for y := b.Min.Y; y < b.Max.Y && ctx.Err() == nil; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			var acc [4]float64
+			for k, weight := range kernel {
+				sx := clampInt(x+(k-half)*dx, b.Min.X, b.Max.X-1)
+				sy := clampInt(y+(k-half)*dy, b.Min.Y, b.Max.Y-1)
+				i := src.PixOffset(sx, sy)
+				for c := 0; c < 4; c++ {
+					acc[c] += weight * float64(src.Pix[i+c])
+				}
+			}
+			i := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[i+c] = clampChannel(acc[c])
+			}
+		}
+	}
+}
+
+// mapPixels applies fn to the straight (non-premultiplied) color of every pixel of img
+func mapPixels(img *image.RGBA, fn func(r, g, b float64) (float64, float64, float64)) {
+	for i := 0; i < len(img.Pix); i += 4 {
+		a := float64(img.Pix[i+3])
+		if a == 0 {
+			continue
+		}
+		unpremultiply := 255 / a
+		r, g, b := fn(float64(img.Pix[i])*unpremultiply, float64(img.Pix[i+1])*unpremultiply, float64(img.Pix[i+2])*unpremultiply)
+		premultiply := a / 255
+		img.Pix[i] = clampChannel(clampFloat(r, 0, 255) * premultiply)
+		img.Pix[i+1] = clampChannel(clampFloat(g, 0, 255) * premultiply)
+		img.Pix[i+2] = clampChannel(clampFloat(b, 0, 255) * premultiply)
+	}
+}
+
+// luminance returns the Rec. 601 luma of an RGB color
+func luminance(r, g, b float64) float64 {
+	return 0.299*r + 0.587*g + 0.114*b
+}
+
+// mix linearly interpolates between a and b
+func mix(a, b, t float64) float64 {
+	return a + (b-a)*t
+}
+
+// defaultAmount returns value, or fallback if value is not set
+func defaultAmount(value, fallback float64) float64 {
+	if value == 0 {
+		return fallback
+	}
+	return value
+}
+
+// clampFloat limits v to the range [lo, hi]
+func clampFloat(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, v))
+}
+
+// clampChannel rounds v to the nearest valid 8-bit channel value
+func clampChannel(v float64) uint8 {
+	return uint8(clampFloat(math.Round(v), 0, 255))
+}
@@ -0,0 +1,51 @@
+package renderer
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"golang.org/x/image/font/opentype"
+)
+
+// fontCache holds the fonts parsed during the run, keyed by path.
+// Parsed fonts are read-only and can be shared by renderers running in parallel.
+var fontCache = struct {
+	sync.Mutex
+	fonts map[string]*opentype.Font
+}{fonts: make(map[string]*opentype.Font)}
+
+// LoadFont returns the parsed font at fontPath, parsing each file only once
+func LoadFont(fontPath string) (*opentype.Font, error) {
+	fontCache.Lock()
+	defer fontCache.Unlock()
+	if parsed, ok := fontCache.fonts[fontPath]; ok {
+		return parsed, nil
+	}
+
+	fontBytes, err := os.ReadFile(fontPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load font file: %w", err)
+	}
+	parsed, err := opentype.Parse(fontBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse font: %w", err)
+	}
+	fontCache.fonts[fontPath] = parsed
+	return parsed, nil
+}
+
+// ForgetFonts empties the font cache, so that fonts edited since they were loaded are
+// parsed again
+func ForgetFonts() {
+	fontCache.Lock()
+	defer fontCache.Unlock()
+	clear(fontCache.fonts)
+}
+
+// ForgetFont drops the font at fontPath from the font cache, so that it is parsed again
+func ForgetFont(fontPath string) {
+	fontCache.Lock()
+	defer fontCache.Unlock()
+	delete(fontCache.fonts, fontPath)
+}
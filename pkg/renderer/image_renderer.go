@@ -1,41 +1,94 @@
 package renderer
 
 import (
-	"fmt"
+	"context"
 	"image"
+	"image/color"
 	"image/draw"
-	"image/jpeg"
-	"image/png"
+	"log"
 	"math"
-	"net/http"
-	"os"
-	"path/filepath"
-	"strings"
-	"time"
 
+	"go-image-generator/pkg/imageio"
 	"go-image-generator/pkg/types"
-
-	xdraw "golang.org/x/image/draw"
 )
 
-type ImageRenderer struct{}
+// ImageRenderer composes background, overlay and speaker images.
+// SmartCrop selects content-aware cropping for speaker images whose template element does not
+// set a crop mode, and DebugCrop logs the crop rectangle chosen for each speaker image.
+// Speaker images whose EXIF orientation was corrected are recorded in Report, if set.
+// Overlay and speaker images are loaded through Images, if set, so that renderers
+// working in parallel share decoded images. Log output goes to Logger, if set.
+// Resample selects the filter, color space and sharpening used to scale images.
+type ImageRenderer struct {
+	SmartCrop bool
+	DebugCrop bool
+	Report    *imageio.Report
+	Images    *imageio.Cache
+	Logger    *log.Logger
+	Resample  types.ResampleConfig
+	// Context stops OverlaySpeakerImages and the filters of its avatars once it is done;
+	// nil never stops
+	Context context.Context
+}
+
+// logf writes to the renderer's logger, or to the standard logger if none is set
+func (ir *ImageRenderer) logf(format string, args ...any) {
+	if ir.Logger != nil {
+		ir.Logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
 
 // RenderBackground takes a background image and produces a final image.
 func (ir *ImageRenderer) RenderBackground(backgroundPath string) (image.Image, error) {
-	background, err := loadImage(backgroundPath)
+	background, err := imageio.Load(backgroundPath)
 	if err != nil {
 		return nil, err
 	}
 	return background, nil
 }
 
+// ComposeBackground places the background image on the canvas configured by the template.
+// Without a canvas, size, position or color, the background is returned unchanged.
+func (ir *ImageRenderer) ComposeBackground(background image.Image, config types.BackgroundConfig) image.Image {
+	b := background.Bounds()
+	size := image.Pt(config.Size.Width, config.Size.Height)
+	if size.X <= 0 || size.Y <= 0 {
+		size = b.Size()
+	}
+	canvas := image.Pt(config.Canvas.Width, config.Canvas.Height)
+	if canvas.X <= 0 || canvas.Y <= 0 {
+		canvas = size
+	}
+	position := image.Pt(config.Position.X, config.Position.Y)
+	if size == b.Size() && canvas == size && position == (image.Point{}) && config.Color == "" {
+		return background
+	}
+
+	dst := image.NewRGBA(image.Rectangle{Max: canvas})
+	if config.Color != "" {
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(parseHexColor(config.Color)), image.Point{}, draw.Src)
+	}
+	ir.scale(dst, image.Rectangle{Min: position, Max: position.Add(size)}, background, b, draw.Over)
+	return dst
+}
+
+// context returns the context that stops rendering, or a background context if there is none
+func (ir *ImageRenderer) context() context.Context {
+	if ir.Context == nil {
+		return context.Background()
+	}
+	return ir.Context
+}
+
 // OverlayImages overlays images on top of the background image.
 func (ir *ImageRenderer) OverlayImages(background image.Image, overlayPaths []string) (image.Image, error) {
 	finalImage := image.NewRGBA(background.Bounds())
 	draw.Draw(finalImage, finalImage.Bounds(), background, image.Point{}, draw.Over)
 
 	for _, overlayPath := range overlayPaths {
-		overlay, err := loadImage(overlayPath)
+		overlay, _, err := ir.Images.Load(overlayPath)
 		if err != nil {
 			return nil, err
 		}
@@ -45,57 +98,102 @@ func (ir *ImageRenderer) OverlayImages(background image.Image, overlayPaths []st
 	return finalImage, nil
 }
 
-// OverlaySpeakerImages overlays speaker images at template-defined positions with circular cropping
-func (ir *ImageRenderer) OverlaySpeakerImages(background *image.RGBA, speaker1Image, speaker2Image string, template *types.Template) error {
+// SpeakerImage is a speaker photo together with its optional framing.
+// Focus is the point of the photo to center in the avatar and Zoom (>= 1) magnifies the photo around it.
+type SpeakerImage struct {
+	Path  string
+	Focus *types.FocalPoint
+	Zoom  float64
+}
+
+// OverlaySpeakerImages overlays speaker images at template-defined positions with circular cropping.
+// Each group of speaker images belongs to one talk and is placed using the image element at
+// the same index; groups with several images are arranged as overlapping or side-by-side avatars.
+func (ir *ImageRenderer) OverlaySpeakerImages(background *image.RGBA, speakerImages [][]SpeakerImage, elements []types.ImageElement) error {
 	bounds := background.Bounds()
-	imgWidth := bounds.Dx()
-	imgHeight := bounds.Dy()
+	groupBounds := SpeakerImageBounds(bounds.Dx(), bounds.Dy(), speakerImages, elements)
 
-	// Position for speaker 1 using template configuration
-	if speaker1Image != "" {
-		speaker1, err := loadImage(speaker1Image)
-		if err != nil {
-			return err
+	for i, group := range speakerImages {
+		if len(group) == 0 || i >= len(elements) {
+			continue
 		}
+		element := elements[i]
+		avatarBounds := groupBounds[i]
 
-		// Calculate position and size from template
-		x := int(template.Speaker1image.Position.X * float64(imgWidth))
-		y := int(template.Speaker1image.Position.Y * float64(imgHeight))
-		size := template.Speaker1image.Size
-
-		// Create bounds centered on the calculated position
-		speaker1Bounds := image.Rect(
-			x-size/2,
-			y-size/2,
-			x+size/2,
-			y+size/2,
-		)
-		ir.scaleImageToFitCircular(background, speaker1, speaker1Bounds)
+		for j, speakerImage := range group {
+			if err := ir.context().Err(); err != nil {
+				return err
+			}
+			speaker, info, err := ir.Images.Load(speakerImage.Path)
+			if err != nil {
+				return err
+			}
+			ir.Report.Add(speakerImage.Path, info)
+			crop := ir.cropWindow(speaker, avatarBounds[j], element, speakerImage)
+			if ir.DebugCrop {
+				ir.logf("[cropWindow] %s (%dx%d): crop rectangle %v", speakerImage.Path, speaker.Bounds().Dx(), speaker.Bounds().Dy(), crop)
+			}
+			ir.scaleImageToFitCircular(background, speaker, crop, avatarBounds[j], element.Filters)
+			if element.BorderWidth > 0 {
+				ir.drawCircleBorder(background, avatarBounds[j], element.BorderWidth, parseHexColor(element.BorderColor))
+			}
+		}
 	}
 
-	// Position for speaker 2 using template configuration
-	if speaker2Image != "" {
-		speaker2, err := loadImage(speaker2Image)
-		if err != nil {
-			return err
+	return nil
+}
+
+// SpeakerImageBounds returns the bounds of the avatar circles that OverlaySpeakerImages
+// draws for each group of speaker images onto an image of width x height
+func SpeakerImageBounds(width, height int, speakerImages [][]SpeakerImage, elements []types.ImageElement) [][]image.Rectangle {
+	bounds := make([][]image.Rectangle, len(speakerImages))
+	for i, group := range speakerImages {
+		if len(group) == 0 || i >= len(elements) {
+			continue
 		}
+		// Calculate position from template and lay out one circle per speaker
+		element := elements[i]
+		center := image.Pt(int(element.Position.X*float64(width)), int(element.Position.Y*float64(height)))
+		bounds[i] = avatarGroupBounds(center, element, len(group))
+	}
+	return bounds
+}
 
-		// Calculate position and size from template
-		x := int(template.Speaker2image.Position.X * float64(imgWidth))
-		y := int(template.Speaker2image.Position.Y * float64(imgHeight))
-		size := template.Speaker2image.Size
-
-		// Create bounds centered on the calculated position
-		speaker2Bounds := image.Rect(
-			x-size/2,
-			y-size/2,
-			x+size/2,
-			y+size/2,
-		)
-		ir.scaleImageToFitCircular(background, speaker2, speaker2Bounds)
+// avatarGroupBounds returns the bounds of count avatar circles centered on center.
+// The group spans the element size horizontally: "side-by-side" groups shrink the circles
+// to fit next to each other with Gap pixels between them, at most half of the size in
+// total so that the circles keep the other half, while the default "overlap"
+// groups let each circle cover Overlap (default 0.3) of its predecessor's diameter.
+func avatarGroupBounds(center image.Point, element types.ImageElement, count int) []image.Rectangle {
+	size := float64(element.Size)
+	diameter := size
+	step := 0.0
+
+	if count > 1 {
+		switch element.Group {
+		case "side-by-side":
+			gap := min(float64(element.Gap), size/2/float64(count-1))
+			diameter = (size - gap*float64(count-1)) / float64(count)
+			step = diameter + gap
+		default:
+			overlap := element.Overlap
+			if overlap <= 0 || overlap >= 1 {
+				overlap = 0.3
+			}
+			diameter = size / (1 + float64(count-1)*(1-overlap))
+			step = diameter * (1 - overlap)
+		}
 	}
 
-	return nil
+	rects := make([]image.Rectangle, count)
+	left := float64(center.X) - size/2
+	d := int(diameter)
+	for i := range rects {
+		x := int(left + float64(i)*step)
+		y := center.Y - d/2
+		rects[i] = image.Rect(x, y, x+d, y+d)
+	}
+	return rects
 }
 
 // ResizeKeepAspect resizes the given image to the specified width while preserving aspect ratio.
@@ -116,63 +214,82 @@ func (ir *ImageRenderer) ResizeKeepAspect(src image.Image, width int) image.Imag
 		height = 1
 	}
 	dst := image.NewRGBA(image.Rect(0, 0, width, height))
-	xdraw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+	ir.scale(dst, dst.Bounds(), src, b, draw.Over)
 	return dst
 }
 
-// scaleImageToFitCircular scales the source image to fit within the specified rectangular bounds,
+// cropWindow returns the region of src that is scaled into bounds.
+// A focal point set for the speaker image centers the window on that point; otherwise the element's
+// crop mode ("smart" or "center") or the renderer's SmartCrop default positions it. A zoom above 1
+// shrinks the window around its center, magnifying the photo.
+func (ir *ImageRenderer) cropWindow(src image.Image, bounds image.Rectangle, element types.ImageElement, speakerImage SpeakerImage) image.Rectangle {
+	srcBounds := src.Bounds()
+
+	var crop image.Rectangle
+	if speakerImage.Focus != nil {
+		w, h := cropWindowSize(srcBounds, bounds)
+		focusX := srcBounds.Min.X + int(speakerImage.Focus.X*float64(srcBounds.Dx()))
+		focusY := srcBounds.Min.Y + int(speakerImage.Focus.Y*float64(srcBounds.Dy()))
+		crop = image.Rect(focusX-w/2, focusY-h/2, focusX-w/2+w, focusY-h/2+h)
+	} else {
+		smart := ir.SmartCrop
+		switch element.Crop {
+		case "smart":
+			smart = true
+		case "center":
+			smart = false
+		}
+
+		if smart {
+			crop = smartCropRect(src, bounds)
+		} else {
+			crop = centerCropRect(srcBounds, bounds)
+		}
+	}
+
+	if speakerImage.Zoom > 1 {
+		w := int(math.Round(float64(crop.Dx()) / speakerImage.Zoom))
+		h := int(math.Round(float64(crop.Dy()) / speakerImage.Zoom))
+		cx := crop.Min.X + crop.Dx()/2
+		cy := crop.Min.Y + crop.Dy()/2
+		crop = image.Rect(cx-w/2, cy-h/2, cx-w/2+w, cy-h/2+h)
+	}
+
+	return keepInside(crop, srcBounds)
+}
+
+// keepInside moves r so that it lies within bounds without changing its size, avoiding empty areas in the avatar
+func keepInside(r image.Rectangle, bounds image.Rectangle) image.Rectangle {
+	x := clampInt(r.Min.X, bounds.Min.X, bounds.Max.X-r.Dx())
+	y := clampInt(r.Min.Y, bounds.Min.Y, bounds.Max.Y-r.Dy())
+	return image.Rect(x, y, x+r.Dx(), y+r.Dy())
+}
+
+// scaleImageToFitCircular scales the crop region of the source image to fill the specified rectangular bounds,
 // then applies a circular crop and draws the result onto the destination image.
 //
 // Parameters:
 //
 //	dst    - The destination RGBA image onto which the circularly cropped image will be drawn.
 //	src    - The source image to be scaled and cropped.
-//	bounds - The rectangle within dst where the circular image should be placed. The circle is inscribed in these bounds.
+//	crop    - The region of src to show, with the same aspect ratio as bounds (see cropWindow).
+//	bounds  - The rectangle within dst where the circular image should be placed. The circle is inscribed in these bounds.
+//	filters - The filter chain applied to the scaled image before masking.
 //
 // Algorithm:
-//  1. Calculates the scale factor needed to ensure the source image fully covers the circular area defined by bounds,
-//     using the larger of the X and Y scale ratios to avoid empty corners.
-//  2. Scales the source image to the calculated size and centers it within the bounds.
+//  1. Scales the crop region of the source image so that it covers the bounds completely, avoiding empty corners.
+//  2. Applies the filter chain to the scaled image.
 //  3. Applies a circular mask to the scaled image, cropping it to a circle inscribed in bounds.
 //  4. Draws the circularly cropped image onto the destination image at the specified bounds.
-func (ir *ImageRenderer) scaleImageToFitCircular(dst *image.RGBA, src image.Image, bounds image.Rectangle) {
-	// First, let's use the old scaling method to make sure the image gets properly scaled
+func (ir *ImageRenderer) scaleImageToFitCircular(dst *image.RGBA, src image.Image, crop image.Rectangle, bounds image.Rectangle, filters []types.ImageFilter) {
 	tempImg := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
 
-	// Use the working scaling logic from scaleImageToFit
-	srcBounds := src.Bounds()
-	srcWidth := float64(srcBounds.Dx())
-	srcHeight := float64(srcBounds.Dy())
-
-	dstWidth := float64(bounds.Dx())
-	dstHeight := float64(bounds.Dy())
-
-	// Calculate scale factor to fill the entire circle (use larger scale to avoid empty corners)
-	scaleX := dstWidth / srcWidth
-	scaleY := dstHeight / srcHeight
-	scale := scaleX
-	if scaleY > scaleX {
-		scale = scaleY // Use larger scale to fill the circle completely
-	}
-
-	// Calculate the actual size after scaling
-	scaledWidth := int(srcWidth * scale)
-	scaledHeight := int(srcHeight * scale)
-
-	// Center the scaled image within the bounds
-	offsetX := (bounds.Dx() - scaledWidth) / 2
-	offsetY := (bounds.Dy() - scaledHeight) / 2
-
-	// Create the target rectangle for the scaled image
-	targetBounds := image.Rect(
-		offsetX,
-		offsetY,
-		offsetX+scaledWidth,
-		offsetY+scaledHeight,
-	)
+	// Scale the crop region to the temporary image
+	ir.scale(tempImg, tempImg.Bounds(), src, crop, draw.Over)
 
-	// Scale the image to the temporary image
-	xdraw.CatmullRom.Scale(tempImg, targetBounds, src, srcBounds, draw.Over, nil)
+	if err := applyFilters(ir.context(), tempImg, filters); err != nil {
+		ir.logf("Warning: Error applying image filters: %v", err)
+	}
 
 	// Now apply circular mask and draw to destination
 	ir.drawCircularImage(dst, tempImg, bounds)
@@ -219,74 +336,23 @@ func (ir *ImageRenderer) drawCircularImage(dst *image.RGBA, src *image.RGBA, bou
 	}
 }
 
-// loadImage is a utility function to load an image from a file.
-func loadImage(path string) (image.Image, error) {
-	// Check if path is a URL
-	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
-		return loadImageFromURL(path)
-	}
-
-	// Handle local file
-	return loadImageFromFile(path)
-}
-
-func loadImageFromURL(url string) (image.Image, error) {
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-	}
-	resp, err := client.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("failed to download image from %s: %w", url, err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to download image from %s: HTTP %d", url, resp.StatusCode)
-	}
-
-	// Determine image format from Content-Type header or URL extension
-	contentType := resp.Header.Get("Content-Type")
-	switch contentType {
-	case "image/png":
-		return png.Decode(resp.Body)
-	case "image/jpeg", "image/jpg":
-		return jpeg.Decode(resp.Body)
-	default:
-		// Fallback: try to determine from URL extension
-		ext := strings.ToLower(filepath.Ext(url))
-		// Remove query parameters from extension check
-		if idx := strings.Index(ext, "?"); idx != -1 {
-			ext = ext[:idx]
-		}
+// drawCircleBorder draws a ring of the given width along the inside edge of the circle inscribed in bounds.
+// It separates overlapping avatars from each other.
+func (ir *ImageRenderer) drawCircleBorder(dst *image.RGBA, bounds image.Rectangle, width int, col color.Color) {
+	c := color.RGBAModel.Convert(col).(color.RGBA)
+	centerX := float64(bounds.Dx()) / 2
+	centerY := float64(bounds.Dy()) / 2
+	radius := math.Min(centerX, centerY)
+	inner := radius - float64(width)
 
-		switch ext {
-		case ".png":
-			return png.Decode(resp.Body)
-		case ".jpg", ".jpeg":
-			return jpeg.Decode(resp.Body)
-		default:
-			// Default to JPEG decoder as most social media images are JPEG
-			return jpeg.Decode(resp.Body)
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			dx := float64(x) + 0.5 - centerX
+			dy := float64(y) + 0.5 - centerY
+			distance := math.Sqrt(dx*dx + dy*dy)
+			if distance <= radius && distance >= inner {
+				dst.SetRGBA(bounds.Min.X+x, bounds.Min.Y+y, c)
+			}
 		}
 	}
 }
-
-func loadImageFromFile(path string) (image.Image, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	// Check file extension to determine decoder
-	ext := strings.ToLower(filepath.Ext(path))
-	switch ext {
-	case ".png":
-		return png.Decode(file)
-	case ".jpg", ".jpeg":
-		return jpeg.Decode(file)
-	default:
-		// Default to JPEG decoder
-		return jpeg.Decode(file)
-	}
-}
@@ -0,0 +1,234 @@
+package renderer
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+
+	"go-image-generator/pkg/types"
+)
+
+// ResizeMode selects how an image is scaled to the size of a ResizeSpec
+type ResizeMode string
+
+const (
+	// ResizeNone keeps the size of the image
+	ResizeNone ResizeMode = ""
+	// ResizeWidth scales to a width, keeping the aspect ratio
+	ResizeWidth ResizeMode = "w"
+	// ResizeHeight scales to a height, keeping the aspect ratio
+	ResizeHeight ResizeMode = "h"
+	// ResizeFit scales the image to fit into a box and letterboxes the rest
+	ResizeFit ResizeMode = "fit"
+	// ResizeFill scales the image to cover a box and crops the rest around a focal point
+	ResizeFill ResizeMode = "fill"
+)
+
+// ResizeSpec describes an output size. Specs are written as "550" or "w=550" for a
+// width, "h=630" for a height, "fit=1200x630" for a letterboxed box, with an optional
+// "#rrggbb" letterbox color, and "fill=1080x1080" for a cropped box. A "@2x" suffix
+// multiplies the pixel size by a density; "0" or "@2x" alone refer to the full size.
+type ResizeSpec struct {
+	Mode    ResizeMode
+	Width   int
+	Height  int
+	Density int
+	// Background is the letterbox color of fit specs; letterboxes are transparent by
+	// default, which JPEG output shows as black.
+	Background string
+}
+
+// ParseResizeSpec parses a resize spec such as "550", "h=630", "fit=1200x630#ffffff"
+// or "fill=1080x1080@2x"
+func ParseResizeSpec(s string) (ResizeSpec, error) {
+	spec := ResizeSpec{Density: 1}
+	rest := strings.TrimSpace(s)
+
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		density, err := strconv.Atoi(strings.TrimSuffix(rest[i+1:], "x"))
+		if err != nil || density < 1 || !strings.HasSuffix(rest, "x") {
+			return ResizeSpec{}, fmt.Errorf("invalid density in resize spec %q", s)
+		}
+		spec.Density = density
+		rest = rest[:i]
+	}
+	if rest == "" || rest == "0" {
+		return spec, nil
+	}
+
+	mode, value, found := strings.Cut(rest, "=")
+	if !found {
+		mode, value = string(ResizeWidth), rest
+	}
+	switch ResizeMode(mode) {
+	case ResizeWidth, ResizeHeight:
+		size, err := strconv.Atoi(value)
+		if err != nil || size <= 0 {
+			return ResizeSpec{}, fmt.Errorf("invalid size in resize spec %q", s)
+		}
+		spec.Mode = ResizeMode(mode)
+		if spec.Mode == ResizeWidth {
+			spec.Width = size
+		} else {
+			spec.Height = size
+		}
+	case ResizeFit, ResizeFill:
+		spec.Mode = ResizeMode(mode)
+		if i := strings.Index(value, "#"); i >= 0 {
+			if spec.Mode != ResizeFit || len(value)-i != 7 {
+				return ResizeSpec{}, fmt.Errorf("invalid letterbox color in resize spec %q", s)
+			}
+			spec.Background = strings.ToLower(value[i:])
+			value = value[:i]
+		}
+		w, h, found := strings.Cut(value, "x")
+		width, errW := strconv.Atoi(w)
+		height, errH := strconv.Atoi(h)
+		if !found || errW != nil || errH != nil || width <= 0 || height <= 0 {
+			return ResizeSpec{}, fmt.Errorf("invalid box in resize spec %q, expected WIDTHxHEIGHT", s)
+		}
+		spec.Width, spec.Height = width, height
+	default:
+		return ResizeSpec{}, fmt.Errorf("unknown resize mode %q in %q", mode, s)
+	}
+	return spec, nil
+}
+
+// String returns the spec in the form parsed by ParseResizeSpec. Widths are written
+// without "w=", and the full size as "" or a density alone.
+func (s ResizeSpec) String() string {
+	var b strings.Builder
+	switch s.Mode {
+	case ResizeWidth:
+		b.WriteString(strconv.Itoa(s.Width))
+	case ResizeHeight:
+		fmt.Fprintf(&b, "h=%d", s.Height)
+	case ResizeFit, ResizeFill:
+		fmt.Fprintf(&b, "%s=%dx%d%s", s.Mode, s.Width, s.Height, s.Background)
+	}
+	if s.Density > 1 {
+		fmt.Fprintf(&b, "@%dx", s.Density)
+	}
+	return b.String()
+}
+
+// Suffix returns the part of an output file name that identifies the spec: "550",
+// "h630", "fit1200x630" or "fill1080x1080", followed by "@2x" for densities. It is
+// empty for the full size.
+func (s ResizeSpec) Suffix() string {
+	var suffix string
+	switch s.Mode {
+	case ResizeWidth:
+		suffix = strconv.Itoa(s.Width)
+	case ResizeHeight:
+		suffix = fmt.Sprintf("h%d", s.Height)
+	case ResizeFit, ResizeFill:
+		suffix = fmt.Sprintf("%s%dx%d", s.Mode, s.Width, s.Height)
+	}
+	if s.Density > 1 {
+		suffix += fmt.Sprintf("@%dx", s.Density)
+	}
+	return suffix
+}
+
+// IsFull reports whether the spec keeps the size of the image
+func (s ResizeSpec) IsFull() bool {
+	return s.Mode == ResizeNone && s.Density <= 1
+}
+
+// Dimensions returns the size of a width x height image resized to the spec
+func (s ResizeSpec) Dimensions(width, height int) (int, int) {
+	density := max(s.Density, 1)
+	if width <= 0 || height <= 0 {
+		return 0, 0
+	}
+	switch s.Mode {
+	case ResizeWidth:
+		return s.Width * density, max(int(math.Round(float64(s.Width*density)*float64(height)/float64(width))), 1)
+	case ResizeHeight:
+		return max(int(math.Round(float64(s.Height*density)*float64(width)/float64(height))), 1), s.Height * density
+	case ResizeFit, ResizeFill:
+		return s.Width * density, s.Height * density
+	default:
+		return width * density, height * density
+	}
+}
+
+// Resize scales src to the spec. Fill specs crop around focus, or the center if focus
+// is nil. The full size returns src unchanged if it already is an RGBA image.
+func (ir *ImageRenderer) Resize(src image.Image, spec ResizeSpec, focus *types.FocalPoint) *image.RGBA {
+	b := src.Bounds()
+	srcW, srcH := b.Dx(), b.Dy()
+	density := spec.Density
+	if density < 1 {
+		density = 1
+	}
+	if srcW == 0 || srcH == 0 {
+		return toRGBA(src)
+	}
+
+	switch spec.Mode {
+	case ResizeWidth:
+		return toRGBA(ir.ResizeKeepAspect(src, spec.Width*density))
+	case ResizeHeight:
+		width := int(math.Round(float64(spec.Height*density) * float64(srcW) / float64(srcH)))
+		return toRGBA(ir.ResizeKeepAspect(src, max(width, 1)))
+	case ResizeFit:
+		boxW, boxH := spec.Width*density, spec.Height*density
+		scale := math.Min(float64(boxW)/float64(srcW), float64(boxH)/float64(srcH))
+		w := max(int(math.Round(float64(srcW)*scale)), 1)
+		h := max(int(math.Round(float64(srcH)*scale)), 1)
+		dst := image.NewRGBA(image.Rect(0, 0, boxW, boxH))
+		if spec.Background != "" {
+			draw.Draw(dst, dst.Bounds(), image.NewUniform(parseHexColor(spec.Background)), image.Point{}, draw.Src)
+		}
+		x, y := (boxW-w)/2, (boxH-h)/2
+		ir.scale(dst, image.Rect(x, y, x+w, y+h), src, b, draw.Over)
+		return dst
+	case ResizeFill:
+		boxW, boxH := spec.Width*density, spec.Height*density
+		dst := image.NewRGBA(image.Rect(0, 0, boxW, boxH))
+		ir.scale(dst, dst.Bounds(), src, fillWindow(b, boxW, boxH, focus), draw.Src)
+		return dst
+	default:
+		if density > 1 {
+			return toRGBA(ir.ResizeKeepAspect(src, srcW*density))
+		}
+		return toRGBA(src)
+	}
+}
+
+// fillWindow returns the largest region of bounds with the aspect ratio of a
+// boxW x boxH box, centered on focus as far as the bounds allow
+func fillWindow(bounds image.Rectangle, boxW, boxH int, focus *types.FocalPoint) image.Rectangle {
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	w, h := srcW, srcH
+	if float64(srcW)*float64(boxH) > float64(srcH)*float64(boxW) {
+		w = max(int(math.Round(float64(srcH)*float64(boxW)/float64(boxH))), 1)
+	} else {
+		h = max(int(math.Round(float64(srcW)*float64(boxH)/float64(boxW))), 1)
+	}
+
+	fx, fy := 0.5, 0.5
+	if focus != nil {
+		fx, fy = focus.X, focus.Y
+	}
+	x := int(math.Round(fx*float64(srcW) - float64(w)/2))
+	y := int(math.Round(fy*float64(srcH) - float64(h)/2))
+	x = clampInt(x, 0, srcW-w)
+	y = clampInt(y, 0, srcH-h)
+	return image.Rect(bounds.Min.X+x, bounds.Min.Y+y, bounds.Min.X+x+w, bounds.Min.Y+y+h)
+}
+
+// toRGBA returns img as an RGBA image, converting it if needed
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return rgba
+}
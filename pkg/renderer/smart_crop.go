@@ -0,0 +1,357 @@
+package renderer
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+const (
+	// smartCropAnalysisSize is the longest side of the downscaled copy used to compute saliency
+	smartCropAnalysisSize = 128
+
+	// Weights of the saliency heuristics
+	smartCropEdgeWeight    = 0.25
+	smartCropSkinWeight    = 0.65
+	smartCropEntropyWeight = 0.10
+
+	// smartCropPositionBias is how much a window at the far end of the sliding range is
+	// penalized compared to the preferred position (center horizontally, top vertically)
+	smartCropPositionBias = 0.1
+
+	// smartCropMinSubject is the minimum mean subject score for a person to be detected
+	smartCropMinSubject = 0.005
+
+	// smartCropSubjectAnchor is where the subject's center sits in a vertically placed window,
+	// as a fraction of the window height from the top
+	smartCropSubjectAnchor = 0.4
+
+	// smartCropTopThreshold is the fraction of the strongest row's saliency from which a row
+	// counts as the top of the subject, and smartCropHeadroom the space kept above it
+	smartCropTopThreshold = 0.3
+	smartCropHeadroom     = 0.05
+
+	// Skin tone ranges in HSV (hue in degrees, saturation and value in [0, 1])
+	skinHueMin        = 0
+	skinHueMax        = 40
+	skinSaturationMin = 0.12
+	skinSaturationMax = 0.55
+	skinValueMin      = 0.35
+)
+
+// centerCropRect returns the largest window with the aspect ratio of target that is centered in src
+func centerCropRect(src image.Rectangle, target image.Rectangle) image.Rectangle {
+	w, h := cropWindowSize(src, target)
+	x := src.Min.X + (src.Dx()-w)/2
+	y := src.Min.Y + (src.Dy()-h)/2
+	return image.Rect(x, y, x+w, y+h)
+}
+
+// cropWindowSize returns the size of the largest window with the aspect ratio of target that fits in src
+func cropWindowSize(src image.Rectangle, target image.Rectangle) (int, int) {
+	srcW, srcH := float64(src.Dx()), float64(src.Dy())
+	targetAspect := float64(target.Dx()) / float64(target.Dy())
+	if srcW/srcH > targetAspect {
+		return int(math.Round(srcH * targetAspect)), src.Dy()
+	}
+	return src.Dx(), int(math.Round(srcW / targetAspect))
+}
+
+// smartCropRect picks the crop window with the aspect ratio of target that frames the
+// subject of src, instead of always using the center.
+//
+// The window is the largest one fitting src, so it can only slide along one axis. Its
+// position is chosen on a downscaled copy of the image from three saliency heuristics:
+//   - edge strength (Sobel gradient of the luminance), which favors detailed regions like faces
+//   - skin tone likelihood (hue, saturation and value ranges), which favors people over background
+//   - local entropy of the luminance, which favors textured over flat regions
+//
+// When a person is found (compact skin regions with detail), the window is centered on them
+// horizontally, or placed with headroom above the top of the subject vertically. Otherwise
+// the position with the highest saliency is used.
+func smartCropRect(src image.Image, target image.Rectangle) image.Rectangle {
+	srcBounds := src.Bounds()
+	center := centerCropRect(srcBounds, target)
+	if srcBounds.Dx() < 2 || srcBounds.Dy() < 2 || center.Size() == srcBounds.Size() {
+		return center
+	}
+
+	// Downscale for analysis, keeping the aspect ratio
+	scale := float64(smartCropAnalysisSize) / math.Max(float64(srcBounds.Dx()), float64(srcBounds.Dy()))
+	if scale > 1 {
+		scale = 1
+	}
+	aw := int(math.Max(1, math.Round(float64(srcBounds.Dx())*scale)))
+	ah := int(math.Max(1, math.Round(float64(srcBounds.Dy())*scale)))
+	small := image.NewRGBA(image.Rect(0, 0, aw, ah))
+	xdraw.ApproxBiLinear.Scale(small, small.Bounds(), src, srcBounds, xdraw.Src, nil)
+
+	saliency, subject := saliencyMap(small)
+
+	// The window slides along the axis where it is smaller than the image
+	horizontal := center.Dx() < srcBounds.Dx()
+	length, window := ah, int(math.Round(float64(center.Dy())*scale))
+	if horizontal {
+		length, window = aw, int(math.Round(float64(center.Dx())*scale))
+	}
+	window = clampInt(window, 1, length)
+
+	// Project the maps onto that axis
+	profile := make([]float64, length)
+	subjectProfile := make([]float64, length)
+	subjectTotal := 0.0
+	for y := 0; y < ah; y++ {
+		for x := 0; x < aw; x++ {
+			i := y
+			if horizontal {
+				i = x
+			}
+			profile[i] += saliency[y*aw+x]
+			subjectProfile[i] += subject[y*aw+x]
+			subjectTotal += subject[y*aw+x]
+		}
+	}
+
+	var pos float64
+	if subjectTotal >= smartCropMinSubject*float64(aw*ah) {
+		pos = subjectWindowPosition(profile, subjectProfile, window, horizontal)
+	} else {
+		pos = salientWindowPosition(profile, window, horizontal)
+	}
+
+	// Map the position back to source coordinates
+	if horizontal {
+		x := srcBounds.Min.X + int(math.Round(pos/scale))
+		x = clampInt(x, srcBounds.Min.X, srcBounds.Max.X-center.Dx())
+		return image.Rect(x, center.Min.Y, x+center.Dx(), center.Max.Y)
+	}
+	y := srcBounds.Min.Y + int(math.Round(pos/scale))
+	y = clampInt(y, srcBounds.Min.Y, srcBounds.Max.Y-center.Dy())
+	return image.Rect(center.Min.X, y, center.Max.X, y+center.Dy())
+}
+
+// subjectWindowPosition places the window around the detected person.
+// Horizontally the window is centered on the subject; vertically the subject's center sits
+// in the upper part of the window and the first salient row (usually the top of the head)
+// keeps some headroom, so foreheads are not cut off.
+func subjectWindowPosition(profile, subjectProfile []float64, window int, horizontal bool) float64 {
+	centroid, total := 0.0, 0.0
+	for i, v := range subjectProfile {
+		centroid += (float64(i) + 0.5) * v
+		total += v
+	}
+	centroid /= total
+
+	if horizontal {
+		return centroid - float64(window)/2
+	}
+
+	pos := centroid - smartCropSubjectAnchor*float64(window)
+	peak := 0.0
+	for _, v := range profile {
+		peak = math.Max(peak, v)
+	}
+	for i, v := range profile {
+		if v >= smartCropTopThreshold*peak {
+			pos = math.Min(pos, float64(i)-smartCropHeadroom*float64(window))
+			break
+		}
+	}
+	return math.Max(0, pos)
+}
+
+// salientWindowPosition returns the window position with the highest saliency.
+// Saliency near the window center counts more than at its edges, so content is framed
+// instead of cut, and a positional prior prefers centered windows horizontally and the
+// top of the image vertically.
+func salientWindowPosition(profile []float64, window int, horizontal bool) float64 {
+	weights := make([]float64, window)
+	for i := range weights {
+		weights[i] = 0.5 + 0.5*math.Sin(math.Pi*(float64(i)+0.5)/float64(window))
+	}
+
+	maxPos := len(profile) - window
+	bestPos, bestScore := maxPos/2, -1.0
+	for pos := 0; pos <= maxPos; pos++ {
+		score := 0.0
+		for i, weight := range weights {
+			score += profile[pos+i] * weight
+		}
+
+		offset := 0.0
+		if maxPos > 0 {
+			if horizontal {
+				offset = math.Abs(float64(pos)-float64(maxPos)/2) / (float64(maxPos) / 2)
+			} else {
+				offset = float64(pos) / float64(maxPos)
+			}
+		}
+		score *= 1 - smartCropPositionBias*offset
+
+		if score > bestScore {
+			bestPos, bestScore = pos, score
+		}
+	}
+	return float64(bestPos)
+}
+
+// saliencyMap returns per-pixel saliency and subject estimates in [0, 1] for img, stored row by row.
+// The subject map keeps compact skin regions that contain detail, i.e. likely faces.
+func saliencyMap(img *image.RGBA) ([]float64, []float64) {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	luma := make([]float64, w*h)
+	skin := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := img.RGBAAt(x, y)
+			luma[y*w+x] = 0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)
+			skin[y*w+x] = skinLikelihood(c)
+		}
+	}
+
+	edges := sobelMagnitude(luma, w, h)
+	entropy := localEntropy(luma, w, h, 2)
+	normalize(edges)
+	normalize(entropy)
+
+	// Scattered skin-colored pixels are mostly background; averaging keeps solid regions
+	skinRegions := boxBlur(skin, w, h, 2)
+	detail := boxBlur(edges, w, h, 2)
+	normalize(detail)
+
+	saliency := make([]float64, w*h)
+	subject := make([]float64, w*h)
+	for i := range saliency {
+		saliency[i] = smartCropEdgeWeight*edges[i] + smartCropSkinWeight*skin[i] + smartCropEntropyWeight*entropy[i]
+		if skinRegions[i] >= 0.5 {
+			subject[i] = skinRegions[i] * detail[i]
+		}
+	}
+	return saliency, subject
+}
+
+// skinLikelihood scores how likely a pixel is skin from its hue, saturation and value.
+// Skin across tones shares a red-orange hue with moderate saturation; strongly saturated
+// pixels (e.g. hair, orange backgrounds) and dark pixels are rejected, and the score
+// falls off linearly near the edges of the ranges.
+func skinLikelihood(c color.RGBA) float64 {
+	r, g, b := float64(c.R)/255, float64(c.G)/255, float64(c.B)/255
+	maxC := math.Max(r, math.Max(g, b))
+	minC := math.Min(r, math.Min(g, b))
+	if maxC != r || maxC == minC {
+		return 0 // skin hues have red as the dominant channel
+	}
+	value := maxC
+	saturation := (maxC - minC) / maxC
+	hue := 60 * (g - b) / (maxC - minC) // degrees, red-dominant range is [-60, 60]
+
+	return rangeScore(hue, skinHueMin, skinHueMax, 10) *
+		rangeScore(saturation, skinSaturationMin, skinSaturationMax, 0.08) *
+		rangeScore(value, skinValueMin, 1, 0.1)
+}
+
+// rangeScore returns 1 for v inside [lo, hi], falling off linearly to 0 at falloff outside it
+func rangeScore(v, lo, hi, falloff float64) float64 {
+	distance := 0.0
+	if v < lo {
+		distance = lo - v
+	} else if v > hi {
+		distance = v - hi
+	}
+	return math.Max(0, 1-distance/falloff)
+}
+
+// sobelMagnitude returns the Sobel gradient magnitude of a grayscale image
+func sobelMagnitude(luma []float64, w, h int) []float64 {
+	at := func(x, y int) float64 {
+		return luma[clampInt(y, 0, h-1)*w+clampInt(x, 0, w-1)]
+	}
+	out := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			gx := at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)
+			gy := at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1)
+			out[y*w+x] = math.Sqrt(gx*gx + gy*gy)
+		}
+	}
+	return out
+}
+
+// localEntropy returns the Shannon entropy of the luminance histogram in a
+// (2*radius+1)² neighbourhood around each pixel, using 16 histogram bins
+func localEntropy(luma []float64, w, h, radius int) []float64 {
+	out := make([]float64, w*h)
+	var hist [16]int
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			hist = [16]int{}
+			n := 0
+			for dy := -radius; dy <= radius; dy++ {
+				for dx := -radius; dx <= radius; dx++ {
+					sx, sy := x+dx, y+dy
+					if sx < 0 || sy < 0 || sx >= w || sy >= h {
+						continue
+					}
+					hist[int(luma[sy*w+sx])>>4]++
+					n++
+				}
+			}
+			entropy := 0.0
+			for _, count := range hist {
+				if count > 0 {
+					p := float64(count) / float64(n)
+					entropy -= p * math.Log2(p)
+				}
+			}
+			out[y*w+x] = entropy
+		}
+	}
+	return out
+}
+
+// boxBlur returns the mean of values in a (2*radius+1)² neighbourhood around each pixel
+func boxBlur(values []float64, w, h, radius int) []float64 {
+	out := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sum, n := 0.0, 0
+			for sy := max(0, y-radius); sy <= min(h-1, y+radius); sy++ {
+				for sx := max(0, x-radius); sx <= min(w-1, x+radius); sx++ {
+					sum += values[sy*w+sx]
+					n++
+				}
+			}
+			out[y*w+x] = sum / float64(n)
+		}
+	}
+	return out
+}
+
+// normalize scales values in place so that the maximum becomes 1
+func normalize(values []float64) {
+	max := 0.0
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	if max == 0 {
+		return
+	}
+	for i := range values {
+		values[i] /= max
+	}
+}
+
+// clampInt limits v to the range [lo, hi]
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
@@ -119,14 +119,9 @@ func parseHexColor(s string) color.Color {
 
 func (tr *TextRenderer) RenderTextWithPositionAndColor(img *image.RGBA, text string, fontPath string, fontSize float64, colStr string, x int, y int) error {
 	col := parseHexColor(colStr)
-	// ...existing code for loading font and face...
-	fontBytes, err := os.ReadFile(fontPath)
+	parsedFont, err := LoadFont(fontPath)
 	if err != nil {
-		return fmt.Errorf("failed to load font file: %w", err)
-	}
-	parsedFont, err := opentype.Parse(fontBytes)
-	if err != nil {
-		return fmt.Errorf("failed to parse font: %w", err)
+		return err
 	}
 	face, err := opentype.NewFace(parsedFont, &opentype.FaceOptions{
 		Size: fontSize,
@@ -0,0 +1,72 @@
+package templates
+
+import (
+	"go-image-generator/pkg/types"
+)
+
+// SelectLayout returns the talk layout to use for an event with talkCount talks.
+//
+// Layouts declared in the template are matched by the largest talk count that does
+// not exceed talkCount, so a layout declared for 3 talks also covers 4 or more.
+// When that layout has fewer slots than talks, the remaining slots are extrapolated
+// by repeating the offset between its last two slots.
+// Without a matching layout the legacy speaker1/speaker2 elements are used, limited
+// to the number of talks so that no placeholder text is shown for missing talks.
+func SelectLayout(template *types.Template, talkCount int) types.TalkLayout {
+	var selected *types.TalkLayout
+	for i := range template.Layouts {
+		layout := &template.Layouts[i]
+		if layout.Talks > talkCount || len(layout.Slots) == 0 {
+			continue
+		}
+		if selected == nil || layout.Talks > selected.Talks {
+			selected = layout
+		}
+	}
+
+	if selected == nil || (selected.Talks == 0 && talkCount > 0) {
+		return legacyLayout(template, talkCount)
+	}
+
+	slots := append([]types.TalkSlot(nil), selected.Slots...)
+	return types.TalkLayout{Talks: talkCount, Slots: extrapolateSlots(slots, talkCount)}
+}
+
+// legacyLayout builds a layout from the speaker1/speaker2 template elements.
+// Events without talks keep both slots so the template placeholders are rendered.
+func legacyLayout(template *types.Template, talkCount int) types.TalkLayout {
+	slots := []types.TalkSlot{
+		{Title: template.Speaker1title, Name: template.Speaker1name, Image: template.Speaker1image},
+		{Title: template.Speaker2title, Name: template.Speaker2name, Image: template.Speaker2image},
+	}
+	if talkCount > 0 && talkCount < len(slots) {
+		slots = slots[:talkCount]
+	}
+	return types.TalkLayout{Talks: talkCount, Slots: slots}
+}
+
+// extrapolateSlots appends slots until there are count of them, continuing the
+// spacing between the last two slots. Layouts with a single slot cannot be extended.
+func extrapolateSlots(slots []types.TalkSlot, count int) []types.TalkSlot {
+	if len(slots) < 2 {
+		return slots
+	}
+	for len(slots) < count {
+		prev := slots[len(slots)-2]
+		last := slots[len(slots)-1]
+		next := last
+		next.Title.Position = stepPosition(prev.Title.Position, last.Title.Position)
+		next.Name.Position = stepPosition(prev.Name.Position, last.Name.Position)
+		next.Image.Position = stepPosition(prev.Image.Position, last.Image.Position)
+		slots = append(slots, next)
+	}
+	return slots
+}
+
+// stepPosition returns the position one step after last, using the distance between prev and last
+func stepPosition(prev, last types.Position) types.Position {
+	return types.Position{
+		X: last.X + (last.X - prev.X),
+		Y: last.Y + (last.Y - prev.Y),
+	}
+}
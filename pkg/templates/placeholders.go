@@ -0,0 +1,25 @@
+package templates
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// Expand fills the placeholders of text from data. Placeholders use Go template
+// syntax, e.g. "{{.title}}" or "{{.company.name}}"; a placeholder naming a field
+// that data does not have is an error. Text without placeholders is returned as is.
+func Expand(text string, data any) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	t, err := template.New("text").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid placeholder in %q: %w", text, err)
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("cannot fill %q: %w", text, err)
+	}
+	return b.String(), nil
+}
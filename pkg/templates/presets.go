@@ -0,0 +1,66 @@
+package templates
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Preset is a named output size for a platform that event images are posted to.
+// A template can declare a variant laid out for the aspect ratio of the preset.
+type Preset struct {
+	Name   string
+	Width  int
+	Height int
+}
+
+// Presets lists the supported output presets
+var Presets = []Preset{
+	{Name: "linkedin", Width: 1200, Height: 627},
+	{Name: "x", Width: 1600, Height: 900},
+	{Name: "instagram-square", Width: 1080, Height: 1080},
+	{Name: "instagram-portrait", Width: 1080, Height: 1350},
+	{Name: "instagram-story", Width: 1080, Height: 1920},
+	{Name: "meetup", Width: 1200, Height: 675},
+	{Name: "youtube-thumbnail", Width: 1280, Height: 720},
+}
+
+// PresetNames returns the names of all presets
+func PresetNames() []string {
+	names := make([]string, len(Presets))
+	for i, preset := range Presets {
+		names[i] = preset.Name
+	}
+	return names
+}
+
+// LookupPreset returns the preset with the given name
+func LookupPreset(name string) (Preset, error) {
+	for _, preset := range Presets {
+		if preset.Name == name {
+			return preset, nil
+		}
+	}
+	return Preset{}, fmt.Errorf("unknown preset %q, expected one of %s", name, strings.Join(PresetNames(), ", "))
+}
+
+// ParsePresets parses a comma-separated list of preset names. "all" selects every preset.
+func ParsePresets(list string) ([]Preset, error) {
+	var presets []Preset
+	seen := map[string]bool{}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		if name == "all" {
+			return Presets, nil
+		}
+		preset, err := LookupPreset(name)
+		if err != nil {
+			return nil, err
+		}
+		seen[name] = true
+		presets = append(presets, preset)
+	}
+	return presets, nil
+}
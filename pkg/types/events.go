@@ -1,10 +1,32 @@
 package types
 
-// Talk represents a talk with title and speaker
+// FocalPoint represents a point in an image as fractions of its width and height
+type FocalPoint struct {
+	X float64 `yaml:"x" json:"x"`
+	Y float64 `yaml:"y" json:"y"`
+}
+
+// Speaker represents a single speaker of a talk.
+// ImageFocus and ImageZoom default to the values of the talk.
+type Speaker struct {
+	Name       string      `yaml:"name"`
+	Image      string      `yaml:"image"`
+	Company    string      `yaml:"company"`
+	ImageFocus *FocalPoint `yaml:"image_focus"`
+	ImageZoom  float64     `yaml:"image_zoom"`
+}
+
+// Talk represents a talk with title and speaker.
+// Co-presented talks can list each speaker in Speakers instead of combining them in Speaker.
+// ImageFocus is the point of the speaker image to center in the avatar and ImageZoom
+// magnifies the image around it.
 type Talk struct {
-	Title   string `yaml:"title"`
-	Speaker string `yaml:"speaker"`
-	Image   string `yaml:"image"`
+	Title      string      `yaml:"title"`
+	Speaker    string      `yaml:"speaker"`
+	Image      string      `yaml:"image"`
+	Speakers   []Speaker   `yaml:"speakers"`
+	ImageFocus *FocalPoint `yaml:"image_focus"`
+	ImageZoom  float64     `yaml:"image_zoom"`
 }
 
 // Event represents an event with talks, host, and date
@@ -21,14 +43,9 @@ type EventsYAML []Event
 
 // EventData represents extracted event information for text rendering
 type EventData struct {
-	Speaker1Title string
-	Speaker1Name  string
-	Speaker1Image string
-	Speaker2Title string
-	Speaker2Name  string
-	Speaker2Image string
-	Sponsor       string
-	Date          string
-	Title         string
-	EventTitle    string
+	Talks      []Talk
+	Sponsor    string
+	Date       string
+	Title      string
+	EventTitle string
 }
@@ -1,5 +1,12 @@
 package types
 
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+)
+
 // Position represents a position with X and Y coordinates
 type Position struct {
 	X float64 `json:"x"`
@@ -16,15 +23,45 @@ type TextElement struct {
 	BoxWidth float64  `json:"boxWidth"`
 }
 
-// ImageElement represents an image element with position and size
+// ImageFilter represents an adjustment applied to speaker photos before they are masked.
+// Type is one of grayscale, duotone, brightness, contrast, saturation, sharpen or blur.
+type ImageFilter struct {
+	Type   string   `json:"type"`
+	Amount float64  `json:"amount"`
+	Radius float64  `json:"radius"`
+	Colors []string `json:"colors"`
+}
+
+// ImageElement represents an image element with position and size.
+// When a talk has several speaker images, Group selects how the avatars share the
+// element: "overlap" (default) or "side-by-side". Crop selects how photos are cropped
+// to the circle: "center" or "smart" (content-aware). Filters are applied to the photo in order.
 type ImageElement struct {
-	Position Position `json:"position"`
-	Size     int      `json:"size"`
+	Position    Position      `json:"position"`
+	Size        int           `json:"size"`
+	Crop        string        `json:"crop"`
+	Group       string        `json:"group"`
+	Overlap     float64       `json:"overlap"`
+	Gap         int           `json:"gap"`
+	BorderColor string        `json:"borderColor"`
+	BorderWidth int           `json:"borderWidth"`
+	Filters     []ImageFilter `json:"filters"`
 }
 
-// BackgroundConfig represents background image configuration
+// PlacedImage is an image element showing a fixed image, such as a logo or, in a render
+// request, a photo named by the data document. Source is the path of the image.
+type PlacedImage struct {
+	ImageElement
+	Source string `json:"source"`
+}
+
+// BackgroundConfig represents background image configuration.
+// The image is drawn at Position with Size (default: its own size) onto a canvas of
+// Canvas size (default: the background size) filled with Color, so that templates for
+// other aspect ratios can reuse a background. Without them, the canvas is the image.
 type BackgroundConfig struct {
 	Image    string `json:"image"`
+	Color    string `json:"color,omitempty"`
 	Position struct {
 		X int `json:"x"`
 		Y int `json:"y"`
@@ -33,18 +70,166 @@ type BackgroundConfig struct {
 		Width  int `json:"width"`
 		Height int `json:"height"`
 	} `json:"size"`
+	Canvas struct {
+		Width  int `json:"width"`
+		Height int `json:"height"`
+	} `json:"canvas,omitzero"`
+}
+
+// TalkSlot groups the elements used to render a single talk
+type TalkSlot struct {
+	Title TextElement  `json:"title"`
+	Name  TextElement  `json:"name"`
+	Image ImageElement `json:"image"`
+}
+
+// TalkLayout represents the talk slots used for events with a given number of talks.
+// A layout declared for N talks is also used for events with more talks when no
+// more specific layout exists.
+type TalkLayout struct {
+	Talks int        `json:"talks"`
+	Slots []TalkSlot `json:"slots"`
+}
+
+// OutputConfig represents default encoding settings for images rendered from a template.
+// Command-line flags take precedence. Subsampling is "4:2:0", "4:2:2" or "4:4:4";
+// MaxBytes lowers the JPEG quality until the file fits.
+type OutputConfig struct {
+	Quality     int    `json:"quality"`
+	Subsampling string `json:"subsampling"`
+	MaxBytes    int    `json:"maxBytes"`
+}
+
+// ResampleConfig represents how images are scaled. Filter is "nearest", "bilinear",
+// "catmullrom" (the default) or "lanczos3"; Linear resamples in linear light instead of
+// sRGB, and Sharpen is the amount of an unsharp mask applied after downscaling.
+type ResampleConfig struct {
+	Filter  string  `json:"filter,omitempty"`
+	Linear  bool    `json:"linear,omitempty"`
+	Sharpen float64 `json:"sharpen,omitempty"`
 }
 
-// Template represents the complete template configuration
+// LintConfig represents the layout checks run on every rendered image. SafeMargin is the
+// distance in pixels from the edges of the full-size image that texts and avatars
+// should keep; 0 disables the check.
+type LintConfig struct {
+	SafeMargin int `json:"safeMargin,omitempty"`
+}
+
+// ContrastConfig represents the contrast check of texts against the pixels behind them.
+// Texts below MinRatio, by default 4.5, or MinRatioLarge for texts of 24px and more, by
+// default 3, are reported. With Candidates, such texts take the candidate color with the
+// highest contrast instead, and if none reaches the ratio, Panel is drawn behind them.
+type ContrastConfig struct {
+	MinRatio      float64      `json:"minRatio,omitempty"`
+	MinRatioLarge float64      `json:"minRatioLarge,omitempty"`
+	Candidates    []string     `json:"candidates,omitempty"`
+	Panel         *PanelConfig `json:"panel,omitempty"`
+}
+
+// PanelConfig represents a backing panel drawn behind texts lacking contrast. Opacity is
+// from 0 to 1, by default 0.6; Padding is the distance in pixels between the text and the
+// edges of the panel, by default 12, and Radius rounds its corners.
+type PanelConfig struct {
+	Color   string  `json:"color"`
+	Opacity float64 `json:"opacity,omitempty"`
+	Padding int     `json:"padding,omitempty"`
+	Radius  int     `json:"radius,omitempty"`
+}
+
+// MetadataConfig represents the creator and copyright embedded in generated images.
+// "{year}" in Copyright is replaced by the year of the event.
+type MetadataConfig struct {
+	Creator   string `json:"creator"`
+	Copyright string `json:"copyright"`
+}
+
+// OutputSize is a resize spec of an image rendered per event, such as "550", "h=630",
+// "fit=1200x630" or "fill=1080x1080@2x". Plain widths may be given as JSON numbers;
+// 0 is the full size of the template.
+type OutputSize string
+
+// UnmarshalJSON accepts a resize spec string or a width number
+func (s *OutputSize) UnmarshalJSON(data []byte) error {
+	var width json.Number
+	if err := json.Unmarshal(data, &width); err == nil {
+		*s = OutputSize(width.String())
+		return nil
+	}
+	var spec string
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return fmt.Errorf("output size must be a width or a resize spec: %w", err)
+	}
+	*s = OutputSize(spec)
+	return nil
+}
+
+// Template represents the complete template configuration.
+// Texts and Images are drawn in addition to the event elements; their text and
+// sources may contain placeholders filled from the data of a render request.
+// Outputs lists the sizes rendered per event; Focus is the point of the composition
+// kept in view when a fill size crops it, by default its center. Variants maps output
+// preset names to the templates laid out for their aspect ratio.
 type Template struct {
-	Background    BackgroundConfig `json:"background"`
-	Speaker1title TextElement      `json:"speaker1title"`
-	Speaker1name  TextElement      `json:"speaker1name"`
-	Speaker1image ImageElement     `json:"speaker1image"`
-	Speaker2title TextElement      `json:"speaker2title"`
-	Speaker2name  TextElement      `json:"speaker2name"`
-	Speaker2image ImageElement     `json:"speaker2image"`
-	Sponsor       TextElement      `json:"sponsor"`
-	Date          TextElement      `json:"date"`
-	Title         TextElement      `json:"title"`
+	Background    BackgroundConfig  `json:"background"`
+	Speaker1title TextElement       `json:"speaker1title"`
+	Speaker1name  TextElement       `json:"speaker1name"`
+	Speaker1image ImageElement      `json:"speaker1image"`
+	Speaker2title TextElement       `json:"speaker2title"`
+	Speaker2name  TextElement       `json:"speaker2name"`
+	Speaker2image ImageElement      `json:"speaker2image"`
+	Sponsor       TextElement       `json:"sponsor"`
+	Date          TextElement       `json:"date"`
+	Title         TextElement       `json:"title"`
+	Layouts       []TalkLayout      `json:"layouts"`
+	Texts         []TextElement     `json:"texts,omitempty"`
+	Images        []PlacedImage     `json:"images,omitempty"`
+	Outputs       []OutputSize      `json:"outputs,omitempty"`
+	Focus         *FocalPoint       `json:"focus,omitempty"`
+	Variants      map[string]string `json:"variants,omitempty"`
+	Output        OutputConfig      `json:"output"`
+	Resample      ResampleConfig    `json:"resample,omitzero"`
+	Metadata      MetadataConfig    `json:"metadata"`
+	Lint          LintConfig        `json:"lint,omitzero"`
+	Contrast      ContrastConfig    `json:"contrast,omitzero"`
+}
+
+// Clone returns a deep copy of t, which can be changed without affecting t
+func (t *Template) Clone() *Template {
+	clone := *t
+	clone.Speaker1image = t.Speaker1image.clone()
+	clone.Speaker2image = t.Speaker2image.clone()
+	clone.Layouts = slices.Clone(t.Layouts)
+	for i, layout := range clone.Layouts {
+		clone.Layouts[i].Slots = slices.Clone(layout.Slots)
+		for j, slot := range clone.Layouts[i].Slots {
+			clone.Layouts[i].Slots[j].Image = slot.Image.clone()
+		}
+	}
+	clone.Texts = slices.Clone(t.Texts)
+	clone.Images = slices.Clone(t.Images)
+	for i, placed := range clone.Images {
+		clone.Images[i].ImageElement = placed.ImageElement.clone()
+	}
+	clone.Outputs = slices.Clone(t.Outputs)
+	if t.Focus != nil {
+		focus := *t.Focus
+		clone.Focus = &focus
+	}
+	clone.Variants = maps.Clone(t.Variants)
+	clone.Contrast.Candidates = slices.Clone(t.Contrast.Candidates)
+	if t.Contrast.Panel != nil {
+		panel := *t.Contrast.Panel
+		clone.Contrast.Panel = &panel
+	}
+	return &clone
+}
+
+// clone returns a copy of e with its own filters
+func (e ImageElement) clone() ImageElement {
+	e.Filters = slices.Clone(e.Filters)
+	for i, filter := range e.Filters {
+		e.Filters[i].Colors = slices.Clone(filter.Colors)
+	}
+	return e
 }
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"go-image-generator/pkg/types"
+)
+
+// EventAltText returns an accessible description of an event image listing the
+// event title, date, talks with their speakers, and the host
+func EventAltText(eventData *types.EventData) string {
+	var sentences []string
+
+	intro := "Event banner"
+	if eventData.EventTitle != "" {
+		intro = "Event banner for " + quote(eventData.EventTitle)
+	}
+	if eventData.Date != "" {
+		date, err := ParseEventDate(eventData.Date)
+		if err != nil {
+			date = eventData.Date
+		}
+		intro += " on " + date
+	}
+	sentences = append(sentences, intro+".")
+
+	var talks []string
+	for _, talk := range eventData.Talks {
+		description := quote(talk.Title)
+		var names []string
+		for _, speaker := range TalkSpeakers(talk) {
+			name := speaker.Name
+			if speaker.Company != "" {
+				name = fmt.Sprintf("%s (%s)", speaker.Name, speaker.Company)
+			}
+			names = append(names, name)
+		}
+		if len(names) > 0 {
+			description += " by " + joinNames(names)
+		}
+		talks = append(talks, description)
+	}
+	switch len(talks) {
+	case 0:
+	case 1:
+		sentences = append(sentences, "Talk: "+talks[0]+".")
+	default:
+		sentences = append(sentences, "Talks: "+strings.Join(talks, "; ")+".")
+	}
+
+	if eventData.Sponsor != "" {
+		sentences = append(sentences, fmt.Sprintf("Hosted by %s.", eventData.Sponsor))
+	}
+	return strings.Join(sentences, " ")
+}
+
+// quote wraps s in double quotes as written, unlike %q, which would escape quotes and
+// backslashes in s for Go
+func quote(s string) string {
+	return `"` + s + `"`
+}
+
+// joinNames joins names as "A", "A and B" or "A, B and C"
+func joinNames(names []string) string {
+	if len(names) == 1 {
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
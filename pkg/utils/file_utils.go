@@ -3,38 +3,21 @@ package utils
 import (
 	"fmt"
 	"image"
-	"image/jpeg"
-	"os"
 	"time"
+
+	"go-image-generator/pkg/imageio"
 )
 
-// LoadImage loads an image from the specified file path.
+// LoadImage loads an image from the specified file path or URL.
+// The format (PNG, JPEG, GIF, WebP, BMP or TIFF) is detected from the file content.
 func LoadImage(filePath string) (image.Image, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	img, err := jpeg.Decode(file)
-	if err != nil {
-		return nil, err
-	}
-
-	return img, nil
+	return imageio.Load(filePath)
 }
 
-// SaveImage saves an image to the specified file path in JPG format.
+// SaveImage saves an image to the specified file path.
+// The format is inferred from the file extension (JPEG, PNG or GIF), defaulting to JPEG.
 func SaveImage(filePath string, img image.Image) error {
-	file, err := os.Create(filePath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	// Use higher quality to reduce compression artifacts
-	opts := &jpeg.Options{Quality: 92}
-	return jpeg.Encode(file, img, opts)
+	return imageio.Save(filePath, img, imageio.EncodeOptions{})
 }
 
 // ParseEventDate parses a date string in "YYYY-MM-DD" format and returns a formatted string like "23rd May 2024"
@@ -2,13 +2,12 @@ package utils
 
 import (
 	"fmt"
-	"io"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"go-image-generator/pkg/imageio"
 	"go-image-generator/pkg/types"
 )
 
@@ -56,18 +55,27 @@ func DownloadSpeakerImages(events []types.Event) error {
 // GetSpeakerImagePath returns the local path for a speaker image if it exists,
 // otherwise attempts to download it from the URL
 func GetSpeakerImagePath(originalPath string, eventID int, talkID int) (string, error) {
+	return resolveSpeakerImagePath(originalPath, fmt.Sprintf("%d-%d", eventID, talkID))
+}
+
+// GetTalkSpeakerImagePath returns the local path for the image of one speaker of a
+// co-presented talk, using the naming convention "{eventID}-{talkID}-{speakerID}"
+func GetTalkSpeakerImagePath(originalPath string, eventID int, talkID int, speakerID int) (string, error) {
+	return resolveSpeakerImagePath(originalPath, fmt.Sprintf("%d-%d-%d", eventID, talkID, speakerID))
+}
+
+// resolveSpeakerImagePath returns the local path for a speaker image stored under baseFilename,
+// downloading it first if it is a URL that has not been cached yet
+func resolveSpeakerImagePath(originalPath string, baseFilename string) (string, error) {
 	// If it's not a URL, return as-is (already a local path)
-	if !strings.HasPrefix(originalPath, "http://") && !strings.HasPrefix(originalPath, "https://") {
+	if !imageio.IsURL(originalPath) {
 		// Clean up the path (remove leading slash if present)
 		cleanPath := strings.TrimPrefix(originalPath, "/")
 		return cleanPath, nil
 	}
 
-	// Generate expected local filename
-	baseFilename := fmt.Sprintf("%d-%d", eventID, talkID)
-
 	// Check if we already have this image locally with any common extension
-	extensions := []string{".jpg", ".jpeg", ".png", ".gif"}
+	extensions := []string{".jpg", ".jpeg", ".png", ".gif", ".webp", ".bmp", ".tiff"}
 	for _, ext := range extensions {
 		localPath := filepath.Join(SpeakerImagesDir, baseFilename+ext)
 		if _, err := os.Stat(localPath); err == nil {
@@ -85,42 +93,58 @@ func GetSpeakerImagePath(originalPath string, eventID int, talkID int) (string,
 	return filepath.Join(SpeakerImagesDir, actualFilename), nil
 }
 
-// downloadImageFromURL downloads an image from a URL and saves it with the specified base filename
-// Returns the actual filename with extension based on the content type or URL
-func downloadImageFromURL(url string, baseFilename string) (string, error) {
-	// Create HTTP client with timeout
-	client := &http.Client{
-		Timeout: 30 * time.Second,
+// TalkSpeakers returns the individual speakers of a talk.
+// Talks with a "speakers" list are returned as-is; otherwise the "speaker" string is
+// split into names on "&", " and " and " und ", but not on commas, which also appear in
+// single names such as "Doe, Jane". A talk image is only attached to the
+// speaker when the talk has a single speaker, since it is shared by all of them.
+func TalkSpeakers(talk types.Talk) []types.Speaker {
+	if len(talk.Speakers) > 0 {
+		return talk.Speakers
 	}
 
-	// Make the request
-	resp, err := client.Get(url)
-	if err != nil {
-		return "", fmt.Errorf("failed to fetch image from %s: %w", url, err)
+	names := splitSpeakerNames(talk.Speaker)
+	speakers := make([]types.Speaker, 0, len(names))
+	for _, name := range names {
+		speakers = append(speakers, types.Speaker{Name: name})
+	}
+	if len(speakers) == 1 {
+		speakers[0].Image = talk.Image
+	}
+	return speakers
+}
+
+// splitSpeakerNames splits a combined speaker string like "Jane Doe & John Doe" into names
+func splitSpeakerNames(speaker string) []string {
+	replacer := strings.NewReplacer(" and ", "&", " und ", "&")
+	var names []string
+	for _, name := range strings.Split(replacer.Replace(speaker), "&") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
 	}
-	defer resp.Body.Close()
+	return names
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to download image from %s: HTTP %d", url, resp.StatusCode)
+// downloadImageFromURL downloads an image from a URL and saves it with the specified base filename
+// Returns the actual filename with extension based on the image format detected from its content
+func downloadImageFromURL(url string, baseFilename string) (string, error) {
+	data, err := imageio.Download(url, 30*time.Second)
+	if err != nil {
+		return "", err
 	}
 
-	// Determine file extension from Content-Type header or URL
-	extension := determineImageExtension(resp.Header.Get("Content-Type"), url)
-	actualFilename := baseFilename + extension
+	// Determine file extension from the image content, rejecting formats that cannot be decoded
+	format := imageio.Sniff(data)
+	if format == "" {
+		return "", fmt.Errorf("unsupported image format downloaded from %s", url)
+	}
+	actualFilename := baseFilename + imageio.Extension(format)
 
 	// Create the full file path
 	filePath := filepath.Join(SpeakerImagesDir, actualFilename)
 
-	// Create the file
-	file, err := os.Create(filePath)
-	if err != nil {
-		return "", fmt.Errorf("failed to create file %s: %w", filePath, err)
-	}
-	defer file.Close()
-
-	// Copy the response body to the file
-	_, err = io.Copy(file, resp.Body)
-	if err != nil {
+	if err := os.WriteFile(filePath, data, 0644); err != nil {
 		// Clean up the partially created file
 		os.Remove(filePath)
 		return "", fmt.Errorf("failed to save image to %s: %w", filePath, err)
@@ -130,37 +154,6 @@ func downloadImageFromURL(url string, baseFilename string) (string, error) {
 	return actualFilename, nil
 }
 
-// determineImageExtension determines the appropriate file extension based on Content-Type or URL
-func determineImageExtension(contentType, url string) string {
-	// First try to determine from Content-Type header
-	switch contentType {
-	case "image/png":
-		return ".png"
-	case "image/jpeg", "image/jpg":
-		return ".jpg"
-	case "image/gif":
-		return ".gif"
-	case "image/webp":
-		return ".webp"
-	}
-
-	// Fallback: try to determine from URL extension
-	ext := strings.ToLower(filepath.Ext(url))
-
-	// Remove query parameters from extension check
-	if idx := strings.Index(ext, "?"); idx != -1 {
-		ext = ext[:idx]
-	}
-
-	switch ext {
-	case ".png", ".jpg", ".jpeg", ".gif", ".webp":
-		return ext
-	default:
-		// Default to .jpg for unknown types
-		return ".jpg"
-	}
-}
-
 // PreprocessEventSpeakerImages downloads all speaker images for events before processing
 // This function modifies the events slice in place
 func PreprocessEventSpeakerImages(events *[]types.Event) error {
@@ -175,15 +168,32 @@ func PreprocessEventSpeakerImages(events *[]types.Event) error {
 
 	for i, event := range *events {
 		for j, talk := range event.Talks {
+			// Generate local filename: {eventID}-{talkID}.{extension}
+			talkID := j + 1 // 1-based indexing for talk IDs
+
+			for k, speaker := range talk.Speakers {
+				if !imageio.IsURL(speaker.Image) {
+					continue
+				}
+
+				// Co-speakers are stored as {eventID}-{talkID}-{speakerID}.{extension}
+				speakerID := k + 1
+				localPath, err := GetTalkSpeakerImagePath(speaker.Image, event.ID, talkID, speakerID)
+				if err != nil {
+					fmt.Printf("Warning: Failed to process image for event %d, talk %d, speaker %d: %v\n", event.ID, talkID, speakerID, err)
+					continue
+				}
+
+				(*events)[i].Talks[j].Speakers[k].Image = "/" + localPath
+				fmt.Printf("Updated speaker image path for event %d, talk %d, speaker %d: %s\n", event.ID, talkID, speakerID, localPath)
+			}
+
 			if talk.Image == "" {
 				continue
 			}
 
 			// Check if it's a URL that needs downloading
 			if strings.HasPrefix(talk.Image, "http://") || strings.HasPrefix(talk.Image, "https://") {
-				// Generate local filename: {eventID}-{talkID}.{extension}
-				talkID := j + 1 // 1-based indexing for talk IDs
-
 				// Check if we already have this image locally
 				localPath, err := GetSpeakerImagePath(talk.Image, event.ID, talkID)
 				if err != nil {